@@ -1 +1,7 @@
 package main
+
+import "github.com/mirzakhany/rest_api_sample/internal/cmd"
+
+func main() {
+	cmd.Execute()
+}
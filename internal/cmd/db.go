@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/db"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "database maintenance commands",
+}
+
+var copyFlags struct {
+	fromBackend string
+	from        string
+	toBackend   string
+	to          string
+}
+
+var dbCopyCmd = &cobra.Command{
+	Use:   "copy",
+	Short: "copy all buckets from one database to another, e.g. to move from bolt to sqlite",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src, err := db.Open(copyFlags.fromBackend, copyFlags.from)
+		if err != nil {
+			return fmt.Errorf("open source db failed: %s", err)
+		}
+		defer src.Close()
+
+		dst, err := db.Open(copyFlags.toBackend, copyFlags.to)
+		if err != nil {
+			return fmt.Errorf("open destination db failed: %s", err)
+		}
+		defer dst.Close()
+
+		return db.Copy(dst, src)
+	},
+}
+
+var reindexFlags struct {
+	bucket string
+	index  string
+}
+
+var dbReindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "rebuild secondary indexes of a bucket",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		names := db.Indexes(reindexFlags.bucket)
+		if reindexFlags.index != "" {
+			names = []string{reindexFlags.index}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("bucket %s has no index", reindexFlags.bucket)
+		}
+		return store.Update(func(tx *db.Tx) error {
+			for _, name := range names {
+				if err := tx.RebuildIndex(reindexFlags.bucket, name); err != nil {
+					return err
+				}
+				fmt.Printf("index %s of bucket %s rebuilt\n", name, reindexFlags.bucket)
+			}
+			return nil
+		})
+	},
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "validate a backup file and replace the configured database with it, the server should be stopped",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(); err != nil {
+			return err
+		}
+		if err := db.RestoreConfig(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("database restored from %s\n", args[0])
+		return nil
+	},
+}
+
+var exportFlags struct {
+	bucket string
+}
+
+var dbExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "write records of a bucket to stdout as JSON Lines",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		out := bufio.NewWriter(os.Stdout)
+		count, err := db.Export(store, exportFlags.bucket, out)
+		if err != nil {
+			return err
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d records exported from bucket %s\n", count, exportFlags.bucket)
+		return nil
+	},
+}
+
+var importFlags struct {
+	bucket string
+	mode   string
+}
+
+var dbImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "read JSON Lines records from stdin into a bucket",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		result, err := db.Import(store, importFlags.bucket, os.Stdin, db.ImportMode(importFlags.mode))
+		fmt.Fprintf(os.Stderr, "%d records written and %d skipped in bucket %s\n", result.Written, result.Skipped, importFlags.bucket)
+		return err
+	},
+}
+
+var reencryptFlags struct {
+	bucket string
+}
+
+var dbReencryptCmd = &cobra.Command{
+	Use:   "reencrypt",
+	Short: "rewrite values of a bucket with the active encryption key, e.g. after a key rotation",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		return store.Update(func(tx *db.Tx) error {
+			count, err := tx.Reencrypt(reencryptFlags.bucket)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d records of bucket %s rewritten\n", count, reencryptFlags.bucket)
+			return nil
+		})
+	},
+}
+
+var dbCompactCmd = &cobra.Command{
+	Use:   "compact <src> <dst>",
+	Short: "copy live data of a bbolt file into a new smaller file, the server should be stopped",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		srcSize, dstSize, err := db.Compact(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s compacted into %s, %d -> %d bytes\n", args[0], args[1], srcSize, dstSize)
+		return nil
+	},
+}
+
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "check consistency of the database and decode records of known buckets, the server should be stopped",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		report, err := db.Check(store)
+		if err != nil {
+			return err
+		}
+		for _, e := range report.Errors {
+			fmt.Printf("inconsistency: %s\n", e)
+		}
+		for _, r := range report.Corrupt {
+			fmt.Printf("corrupt record: bucket %s key %q: %s\n", r.Bucket, r.Key, r.Err)
+		}
+		if !report.OK() {
+			return fmt.Errorf("%d inconsistencies and %d corrupt records found", len(report.Errors), len(report.Corrupt))
+		}
+		fmt.Printf("database is ok, %d records checked\n", report.Records)
+		return nil
+	},
+}
+
+var dbSnapshotCmd = &cobra.Command{
+	Use:   "snapshot <file>",
+	Short: "write a consistent copy of the database to a bbolt file which reports can open read-only, the database is opened read-only. while a server owns a bolt file use GET /admin/backup",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(); err != nil {
+			return err
+		}
+		store, err := db.OpenConfigReadOnly()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		snapshot, err := db.Snapshot(store, args[0])
+		if err != nil {
+			return err
+		}
+		if err := snapshot.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("snapshot written to %s\n", args[0])
+		return nil
+	},
+}
+
+// openStore open the configured store without running the registry, so
+// migrations and background jobs of the server do not run in commands
+func openStore() (db.Store, error) {
+	if err := loadConfig(); err != nil {
+		return nil, err
+	}
+	return db.OpenConfig()
+}
+
+func init() {
+	dbCopyCmd.Flags().StringVar(&copyFlags.fromBackend, "from-backend", db.BoltBackend, "source db backend")
+	dbCopyCmd.Flags().StringVar(&copyFlags.from, "from", "", "source db path")
+	dbCopyCmd.Flags().StringVar(&copyFlags.toBackend, "to-backend", db.SQLiteBackend, "destination db backend")
+	dbCopyCmd.Flags().StringVar(&copyFlags.to, "to", "", "destination db path")
+	_ = dbCopyCmd.MarkFlagRequired("from")
+	_ = dbCopyCmd.MarkFlagRequired("to")
+
+	dbReindexCmd.Flags().StringVar(&reindexFlags.bucket, "bucket", "", "bucket to rebuild its indexes")
+	dbReindexCmd.Flags().StringVar(&reindexFlags.index, "index", "", "index to rebuild, all indexes of bucket if empty")
+	_ = dbReindexCmd.MarkFlagRequired("bucket")
+
+	dbExportCmd.Flags().StringVar(&exportFlags.bucket, "bucket", "", "bucket to export")
+	_ = dbExportCmd.MarkFlagRequired("bucket")
+
+	dbImportCmd.Flags().StringVar(&importFlags.bucket, "bucket", "", "bucket to import into")
+	dbImportCmd.Flags().StringVar(&importFlags.mode, "mode", string(db.ImportUpsert), "what to do with existing keys: upsert, skip or fail")
+	_ = dbImportCmd.MarkFlagRequired("bucket")
+
+	dbReencryptCmd.Flags().StringVar(&reencryptFlags.bucket, "bucket", "", "bucket to rewrite")
+	_ = dbReencryptCmd.MarkFlagRequired("bucket")
+
+	dbCmd.AddCommand(dbCopyCmd)
+	dbCmd.AddCommand(dbReindexCmd)
+	dbCmd.AddCommand(dbRestoreCmd)
+	dbCmd.AddCommand(dbExportCmd)
+	dbCmd.AddCommand(dbImportCmd)
+	dbCmd.AddCommand(dbReencryptCmd)
+	dbCmd.AddCommand(dbCompactCmd)
+	dbCmd.AddCommand(dbCheckCmd)
+	dbCmd.AddCommand(dbSnapshotCmd)
+	rootCmd.AddCommand(dbCmd)
+}
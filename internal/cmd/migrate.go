@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/db"
+	"github.com/spf13/cobra"
+)
+
+var migrateFlags struct {
+	dryRun bool
+	steps  int
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "apply or revert data migrations",
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "apply all pending migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMigrationStore(func(store db.Store) error {
+			list, err := db.MigrateUp(store, migrateFlags.dryRun)
+			if err != nil {
+				return err
+			}
+			printMigrations("applied", list)
+			return nil
+		})
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "revert the last applied migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMigrationStore(func(store db.Store) error {
+			list, err := db.MigrateDown(store, migrateFlags.steps, migrateFlags.dryRun)
+			if err != nil {
+				return err
+			}
+			printMigrations("reverted", list)
+			return nil
+		})
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show applied and pending migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMigrationStore(func(store db.Store) error {
+			applied, pending, err := db.MigrationStatus(store)
+			if err != nil {
+				return err
+			}
+			for _, m := range applied {
+				fmt.Printf("applied  %d  %s  (%s)\n", m.Version, m.Description, m.AppliedAt.Format("2006-01-02 15:04:05"))
+			}
+			for _, m := range pending {
+				fmt.Printf("pending  %d  %s\n", m.Version, m.Description)
+			}
+			return nil
+		})
+	},
+}
+
+// withMigrationStore open the configured store without running the registry,
+// so startup migrations do not interfere with the command
+func withMigrationStore(fn func(store db.Store) error) error {
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	store, err := db.OpenConfig()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return fn(store)
+}
+
+func printMigrations(action string, list []db.Migration) {
+	if migrateFlags.dryRun {
+		action = "would be " + action
+	}
+	if len(list) == 0 {
+		fmt.Println("no migration to run")
+	}
+	for _, m := range list {
+		fmt.Printf("%s  %d  %s\n", action, m.Version, m.Description)
+	}
+}
+
+func init() {
+	migrateCmd.PersistentFlags().BoolVar(&migrateFlags.dryRun, "dry-run", false, "run migrations in a transaction which is rolled back")
+	migrateDownCmd.Flags().IntVar(&migrateFlags.steps, "steps", 1, "number of migrations to revert")
+
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -1 +1,91 @@
 package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/db"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+	"github.com/mirzakhany/rest_api_sample/pkg/web"
+	"github.com/spf13/cobra"
+
+	// services register themselves in registry
+	_ "github.com/mirzakhany/rest_api_sample/services/admin"
+	_ "github.com/mirzakhany/rest_api_sample/services/tasks"
+)
+
+const appName = "rest_api_sample"
+
+var rootCmd = &cobra.Command{
+	Use:   "server",
+	Short: "golang RestAPI sample server",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		parent, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			cancel()
+		}()
+
+		router := web.New()
+		ctx, err := setup(parent, func(ctx *projectx.Ctx) {
+			ctx.Set(web.ContextKey, router)
+		})
+		if err != nil {
+			return err
+		}
+		err = web.Run(ctx, router)
+		// let background jobs finish before the store is closed
+		cancel()
+		ctx.Wait()
+		closeStore(ctx)
+		return err
+	},
+}
+
+// setup load config and run registered items, prepare can
+// add values to project context before items are run.
+// background jobs started by items stop when parent is done
+func setup(parent context.Context, prepare func(ctx *projectx.Ctx)) (*projectx.Ctx, error) {
+	if err := loadConfig(); err != nil {
+		return nil, err
+	}
+
+	ctx := projectx.New(parent)
+	if prepare != nil {
+		prepare(ctx)
+	}
+	_, errs := registry.Run(ctx)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("setup failed: %v", errs)
+	}
+	return ctx, nil
+}
+
+// closeStore close the store opened by setup
+func closeStore(ctx *projectx.Ctx) {
+	if i, ok := ctx.Get(db.ContextKey); ok {
+		if err := i.(db.Store).Close(); err != nil {
+			log.Printf("close db failed: %s", err)
+		}
+	}
+}
+
+func loadConfig() error {
+	return config.Init("config", "yaml", appName)
+}
+
+// Execute run the command line, it will exit with non-zero code on error
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+	"go.etcd.io/bbolt"
+)
+
+// backup file names are ordered by the time they are taken
+const (
+	backupPrefix     = "backup-"
+	backupExt        = ".db"
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+var (
+	backupDir      = config.RegisterString("db.backup.dir", "")
+	backupInterval = config.RegisterString("db.backup.interval", "24h")
+	backupKeep     = config.RegisterInt64("db.backup.keep", 7)
+)
+
+// Backup write a consistent snapshot of the database to w as a bbolt file,
+// it runs in a read transaction so writes are not blocked meanwhile
+func (s *Service) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := s.DB.View(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// copyBackup write a snapshot of a non bbolt store by copying it into
+// a temporary bbolt file, so backups of all backends share one format
+func copyBackup(s Store, w io.Writer) (int64, error) {
+	dir, err := ioutil.TempDir("", "db-backup")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp, err := New(filepath.Join(dir, "backup.db"))
+	if err != nil {
+		return 0, err
+	}
+	defer tmp.Close()
+
+	if err := Copy(tmp, s); err != nil {
+		return 0, err
+	}
+	return tmp.Backup(w)
+}
+
+// openBackup open a backup file without modifying it
+func openBackup(file string) (*Service, error) {
+	s, err := New(file, WithReadOnly(true), WithTimeout(time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup %s: %s", file, err)
+	}
+	return s, nil
+}
+
+// ValidateBackup check file is a consistent bbolt database
+func ValidateBackup(file string) error {
+	s, err := openBackup(file)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.DB.View(func(tx *bbolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("backup %s is corrupted: %v", file, errs)
+		}
+		return nil
+	})
+}
+
+// Restore replace the database of backend at path with the backup file.
+// the file is validated first. a bolt database open by a running server is
+// not replaced, ErrLockTimeout is returned. other backends should not be
+// restored while a server is running
+func Restore(backend, path, file string) error {
+	if err := ValidateBackup(file); err != nil {
+		return err
+	}
+	if backend == BoltBackend {
+		return swapFile(file, path)
+	}
+
+	src, err := openBackup(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := Open(backend, path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return src.View(func(stx *Tx) error {
+		return dst.Update(func(dtx *Tx) error {
+			buckets, err := dtx.tx.buckets()
+			if err != nil {
+				return err
+			}
+			for _, bucketName := range buckets {
+				if err := dtx.tx.deleteBucket(bucketName); err != nil {
+					return err
+				}
+			}
+			return copyTx(dtx, stx)
+		})
+	})
+}
+
+// RestoreConfig restore the store configured by `db.backend` and `db.path` from file
+func RestoreConfig(file string) error {
+	return Restore(backendName.String(), dbPath.String(), file)
+}
+
+// swapFile copy file next to path and rename it over path,
+// so path is never left half written
+func swapFile(file, path string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// hold the file lock while swapping, a server writing to the old
+	// file after the rename would lose every write
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreLockTimeout is how long Restore wait for the lock of the database
+const restoreLockTimeout = time.Second
+
+// lockFile take the file lock of the bbolt file at path, it fail with
+// ErrLockTimeout if another process has it open. a missing file or one
+// which is not a valid bbolt file can not be in use, it is not locked
+func lockFile(path string) (func(), error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return func() {}, nil
+	}
+	s, err := New(path, WithTimeout(restoreLockTimeout))
+	if errors.Is(err, ErrLockTimeout) {
+		return nil, fmt.Errorf("restore %s: %w, stop the server first", path, ErrLockTimeout)
+	}
+	if err != nil {
+		return func() {}, nil
+	}
+	return func() { _ = s.Close() }, nil
+}
+
+// BackupToDir write a snapshot of s into dir and remove the oldest
+// backups so at most keep of them remain, keep <= 0 keep all of them
+func BackupToDir(s Store, dir string, keep int) (string, error) {
+	tmp, err := ioutil.TempFile(dir, "."+backupPrefix+"*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := s.Backup(tmp); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(dir, backupPrefix+time.Now().UTC().Format(backupTimeFormat)+backupExt)
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return "", err
+	}
+	return name, pruneBackups(dir, keep)
+}
+
+// Backups return backup files of dir, oldest first
+func Backups(dir string) ([]string, error) {
+	list, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+backupExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	list, err := Backups(dir)
+	if err != nil {
+		return err
+	}
+	for len(list) > keep {
+		if err := os.Remove(list[0]); err != nil {
+			return err
+		}
+		list = list[1:]
+	}
+	return nil
+}
+
+// scheduleBackups take a backup every interval until ctx is done
+func scheduleBackups(ctx *projectx.Ctx, s Store, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			name, err := BackupToDir(s, dir, keep)
+			if err != nil {
+				log.Printf("scheduled backup failed: %s", err)
+				continue
+			}
+			log.Printf("scheduled backup written to %s", name)
+		}
+	}
+}
+
+func init() {
+	// start scheduled backups when a backup directory is configured
+	registry.Register(func(ctx *projectx.Ctx) error {
+		dir := backupDir.String()
+		if dir == "" {
+			return nil
+		}
+		interval, err := time.ParseDuration(backupInterval.String())
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid db.backup.interval `%s`", backupInterval.String())
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		i, ok := ctx.Get(ContextKey)
+		if !ok {
+			return fmt.Errorf("could not get database from context")
+		}
+		ctx.Go(func() {
+			scheduleBackups(ctx, i.(Store), dir, interval, int(backupKeep.Int64()))
+		})
+		return nil
+	}, 2, false)
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBackup write a backup of s into a file of a temp dir
+func writeBackup(t *testing.T, s Store) string {
+	t.Helper()
+	f, err := ioutil.TempFile(t.TempDir(), "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := s.Backup(f); err != nil {
+		t.Fatalf("backup failed %s", err)
+	}
+	return f.Name()
+}
+
+func testStoreBackup(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "a", "1")
+	mustSet(t, s, "test", "b", "2")
+
+	file := writeBackup(t, s)
+	if err := ValidateBackup(file); err != nil {
+		t.Fatalf("backup is invalid %s", err)
+	}
+
+	b, err := openBackup(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	all, err := b.GetAll("test")
+	if err != nil || len(all) != 2 || string(all[1].Val) != "2" {
+		t.Errorf("unexpected backup content %v, %v", all, err)
+	}
+	version, err := b.Version("a", "test")
+	if err != nil || version != 1 {
+		t.Errorf("backup should keep record versions, got %d, %v", version, err)
+	}
+}
+
+func TestValidateBackup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "invalid.db")
+	if err := ioutil.WriteFile(file, []byte("not a bolt file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateBackup(file); err == nil {
+		t.Error("validate should fail for an invalid file")
+	}
+	if err := ValidateBackup(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("validate should fail for a missing file")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	for _, backend := range []string{BoltBackend, SQLiteBackend} {
+		t.Run(backend, func(t *testing.T) {
+			src := NewMemory()
+			mustCreateBucket(t, src, "test")
+			mustSet(t, src, "test", "a", "1")
+			file := writeBackup(t, src)
+
+			path := filepath.Join(t.TempDir(), "restore.db")
+			dst, err := Open(backend, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustCreateBucket(t, dst, "stale")
+			mustCreateBucket(t, dst, "test")
+			mustSet(t, dst, "test", "b", "2")
+			_ = dst.Close()
+
+			if err := Restore(backend, path, file); err != nil {
+				t.Fatalf("restore failed %s", err)
+			}
+
+			dst, err = Open(backend, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+
+			buckets, err := dst.Buckets()
+			if err != nil || len(buckets) != 1 || buckets[0] != "test" {
+				t.Errorf("unexpected buckets after restore %v, %v", buckets, err)
+			}
+			all, err := dst.GetAll("test")
+			if err != nil || len(all) != 1 || all[0].Key != "a" {
+				t.Errorf("unexpected data after restore %v, %v", all, err)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "restore.db")
+	invalid := filepath.Join(t.TempDir(), "invalid.db")
+	_ = ioutil.WriteFile(invalid, []byte("not a bolt file"), 0600)
+	if err := Restore(BoltBackend, path, invalid); err == nil {
+		t.Error("restore should fail for an invalid backup")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("invalid backup should not be swapped in")
+	}
+}
+
+func TestRestore_Locked(t *testing.T) {
+	src := NewMemory()
+	mustCreateBucket(t, src, "test")
+	file := writeBackup(t, src)
+
+	// a running server hold the lock of its database
+	path := filepath.Join(t.TempDir(), "restore.db")
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustCreateBucket(t, s, "live")
+
+	err = Restore(BoltBackend, path, file)
+	_ = s.Close()
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("restore of a locked database should fail with ErrLockTimeout, got %v", err)
+	}
+	s, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if buckets, _ := s.Buckets(); len(buckets) != 1 || buckets[0] != "live" {
+		t.Errorf("locked database should not be replaced, got %v", buckets)
+	}
+}
+
+func TestBackupToDir(t *testing.T) {
+	s := NewMemory()
+	mustCreateBucket(t, s, "test")
+	dir := t.TempDir()
+
+	var names []string
+	for i := 0; i < 4; i++ {
+		name, err := BackupToDir(s, dir, 2)
+		if err != nil {
+			t.Fatalf("backup to dir failed %s", err)
+		}
+		names = append(names, name)
+	}
+
+	list, err := Backups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0] != names[2] || list[1] != names[3] {
+		t.Errorf("only the latest two backups should be kept, got %v", list)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("temporary files should be removed, got %d files", len(files))
+	}
+}
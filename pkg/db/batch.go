@@ -0,0 +1,73 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+)
+
+// batchChunk is the number of records BatchSet and BatchSetJson write in a transaction
+var batchChunk = config.RegisterInt64("db.batch.chunk", 1000)
+
+// JsonKeyVal is a record written by BatchSetJson
+type JsonKeyVal struct {
+	Key string
+	Val interface{}
+}
+
+// BatchSet set values of keys in bucket
+func (tx *Tx) BatchSet(data []KeyVal, bucketName string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.checkBucket(bucketName); err != nil {
+		return err
+	}
+	for _, kVal := range data {
+		if kVal.Key == "" {
+			return fmt.Errorf("key required")
+		}
+		if err := tx.put(kVal.Key, bucketName, kVal.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchSetJson encode values with the bucket codec like SetJson and set them
+func (tx *Tx) BatchSetJson(data []JsonKeyVal, bucketName string) error {
+	encoded := make([]KeyVal, 0, len(data))
+	for _, item := range data {
+		buf, err := encodeValue(bucketName, item.Val)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, KeyVal{Key: item.Key, Val: buf})
+	}
+	return tx.BatchSet(encoded, bucketName)
+}
+
+// writeChunks call fn for chunks of size items, each in its own transaction,
+// so a bulk load does not pay a commit per record nor hold one huge
+// transaction. it return the number of items in committed chunks,
+// which are kept if a later chunk fails. zero size write all items at once
+func writeChunks[T any](s Store, items []T, size int, fn func(tx *Tx, chunk []T) error) (int, error) {
+	if size <= 0 {
+		size = len(items)
+	}
+	written := 0
+	for written < len(items) {
+		end := written + size
+		if end > len(items) {
+			end = len(items)
+		}
+		err := s.Update(func(tx *Tx) error {
+			return fn(tx, items[written:end])
+		})
+		if err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
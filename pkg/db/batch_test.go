@@ -0,0 +1,120 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func testStoreBatch(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	n, err := s.BatchSet([]KeyVal{{Key: "a", Val: []byte("1")}, {Key: "b", Val: []byte("2")}}, "test")
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 records written but got %d, %v", n, err)
+	}
+	n, err = s.BatchSetJson([]JsonKeyVal{{Key: "c", Val: 3}}, "test")
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 record written but got %d, %v", n, err)
+	}
+	var c int
+	if err := s.GetJson("c", "test", &c); err != nil || c != 3 {
+		t.Errorf("expected 3 but got %d, %v", c, err)
+	}
+	if v, _ := s.Version("a", "test"); v != 1 {
+		t.Errorf("batch writes should be versioned, got version %d", v)
+	}
+
+	if _, err := s.BatchSet([]KeyVal{{Key: "k", Val: []byte("1")}}, "not-exist"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("expected ErrBucketNotFound but got %v", err)
+	}
+	if n, err := s.BatchSet([]KeyVal{{Key: "", Val: []byte("1")}}, "test"); err == nil || n != 0 {
+		t.Errorf("empty key should fail, got %d, %v", n, err)
+	}
+
+	err = s.Batch(func(tx *Tx) error {
+		return tx.Set("d", "test", []byte("4"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := s.GetOne("d", "test"); string(val) != "4" {
+		t.Errorf("expected 4 but got %q", val)
+	}
+	errAbort := errors.New("abort")
+	if err := s.Batch(func(tx *Tx) error {
+		_ = tx.Set("e", "test", []byte("5"))
+		return errAbort
+	}); err != errAbort {
+		t.Errorf("expected abort error but got %v", err)
+	}
+	if ok, _ := s.IsExist("e", "test"); ok {
+		t.Error("failed batch should be rolled back")
+	}
+}
+
+func TestWriteChunks(t *testing.T) {
+	s := NewMemory()
+	mustCreateBucket(t, s, "test")
+
+	var data []KeyVal
+	for i := 0; i < 10; i++ {
+		data = append(data, KeyVal{Key: fmt.Sprintf("%02d", i), Val: []byte("v")})
+	}
+	// the last record fail, so the last chunk is rolled back
+	data[9].Key = ""
+
+	chunks := 0
+	n, err := writeChunks(s, data, 4, func(tx *Tx, chunk []KeyVal) error {
+		chunks++
+		return tx.BatchSet(chunk, "test")
+	})
+	if err == nil || n != 8 || chunks != 3 {
+		t.Fatalf("expected 8 records in 2 committed chunks but got %d in %d, %v", n, chunks-1, err)
+	}
+	all, _ := s.GetAll("test")
+	if len(all) != 8 {
+		t.Errorf("expected 8 records but got %d", len(all))
+	}
+
+	n, err = writeChunks(s, data[:9], 0, func(tx *Tx, chunk []KeyVal) error {
+		if len(chunk) != 9 {
+			t.Errorf("zero size should write all records at once, got %d", len(chunk))
+		}
+		return tx.BatchSet(chunk, "test")
+	})
+	if err != nil || n != 9 {
+		t.Errorf("expected 9 records written but got %d, %v", n, err)
+	}
+}
+
+func TestService_BatchConcurrent(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mustCreateBucket(t, s, "test")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.Batch(func(tx *Tx) error {
+				return tx.Set(fmt.Sprintf("%02d", i), "test", []byte("v"))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	all, err := s.GetAll("test")
+	if err != nil || len(all) != 50 {
+		t.Errorf("expected 50 records but got %d, %v", len(all), err)
+	}
+}
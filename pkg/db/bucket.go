@@ -0,0 +1,53 @@
+package db
+
+// Bucket is a handle to one bucket in a transaction, so a sequence of
+// operations on the same bucket do not repeat its name. it is only
+// valid during the transaction
+type Bucket struct {
+	tx   *Tx
+	name string
+}
+
+// Bucket return a handle to bucketName, existence of the bucket
+// is checked by each operation
+func (tx *Tx) Bucket(bucketName string) *Bucket {
+	return &Bucket{tx: tx, name: bucketName}
+}
+
+// Name return the bucket name
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+func (b *Bucket) IsExist(key string) (bool, error) {
+	return b.tx.IsExist(key, b.name)
+}
+
+func (b *Bucket) Get(key string) ([]byte, error) {
+	return b.tx.GetOne(key, b.name)
+}
+
+func (b *Bucket) GetAll() ([]KeyVal, error) {
+	return b.tx.GetAll(b.name)
+}
+
+func (b *Bucket) Set(key string, value []byte) error {
+	return b.tx.Set(key, b.name, value)
+}
+
+func (b *Bucket) Delete(key string) error {
+	return b.tx.Delete(key, b.name)
+}
+
+func (b *Bucket) GetJson(key string, ret interface{}) error {
+	return b.tx.GetJson(key, b.name, ret)
+}
+
+func (b *Bucket) SetJson(key string, value interface{}) error {
+	return b.tx.SetJson(key, b.name, value)
+}
+
+// Cursor return a cursor over bucket keys, see Tx.Cursor
+func (b *Bucket) Cursor() (Cursor, error) {
+	return b.tx.Cursor(b.name)
+}
@@ -0,0 +1,362 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+)
+
+// ChangeOp is the kind of a change
+type ChangeOp string
+
+// change operations
+const (
+	OpSet    ChangeOp = "set"
+	OpDelete ChangeOp = "delete"
+	// OpGap is sent by Watch in place of changes trimmed from the log before
+	// they were read or dropped with the bucket, Seq is the last lost one.
+	// watchers should resync
+	OpGap ChangeOp = "gap"
+)
+
+// ErrChangesTrimmed is returned when changes after the requested seq are
+// trimmed from the change log, readers should resync the bucket
+var ErrChangesTrimmed = errors.New("changes are trimmed from the change log")
+
+// Change is an entry of a bucket change log, it does not carry the
+// value, watchers read the record if they need it
+type Change struct {
+	// Seq is increased by one for every change of the bucket
+	Seq    uint64   `json:"seq"`
+	Bucket string   `json:"bucket"`
+	Key    string   `json:"key"`
+	Op     ChangeOp `json:"op"`
+	// Version is the record version after the change, zero for deletes
+	Version uint64    `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// watchPollInterval is how often watchers look for changes committed by
+// other processes, changes of this process wake them up immediately
+const watchPollInterval = time.Second
+
+// watchBatch is the number of changes a watcher read in a transaction
+const watchBatch = 100
+
+var changesKeep = config.RegisterInt64("db.changes.keep", 10000)
+
+var (
+	changeLogsMu sync.RWMutex
+	changeLogs   = make(map[string]bool)
+)
+
+// RegisterChangeLog record every write to bucket in its change log, so it can be watched
+func RegisterChangeLog(bucketName string) {
+	changeLogsMu.Lock()
+	defer changeLogsMu.Unlock()
+	changeLogs[bucketName] = true
+}
+
+func hasChangeLog(bucketName string) bool {
+	changeLogsMu.RLock()
+	defer changeLogsMu.RUnlock()
+	return changeLogs[bucketName]
+}
+
+// lastSeqKey and firstSeqKey hold the last seq and the oldest kept seq in
+// changes bucket, they can not collide with 8 bytes seq keys
+const (
+	lastSeqKey  = "last"
+	firstSeqKey = "first"
+)
+
+// changesBucket is the companion bucket holding change log of bucketName, keyed by big-endian seq
+func changesBucket(bucketName string) string {
+	return internalPrefix + "changes." + bucketName
+}
+
+func seqKey(seq uint64) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return string(buf)
+}
+
+// LastChangeSeq return seq of the last change of bucket, zero if it has no change
+func (tx *Tx) LastChangeSeq(bucketName string) (uint64, error) {
+	cb := changesBucket(bucketName)
+	ok, err := tx.tx.bucketExist(cb)
+	if err != nil || !ok {
+		return 0, err
+	}
+	v, err := tx.tx.get(lastSeqKey, cb)
+	if err != nil || len(v) != 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// logChange append a change of key to the bucket change log, the log is trimmed
+// to the last `db.changes.keep` changes. it is no-op if bucket has no change log
+func (tx *Tx) logChange(key, bucketName string, op ChangeOp) error {
+	if !hasChangeLog(bucketName) {
+		return nil
+	}
+	last, err := tx.LastChangeSeq(bucketName)
+	if err != nil {
+		return err
+	}
+	change := Change{
+		Seq:    last + 1,
+		Bucket: bucketName,
+		Key:    key,
+		Op:     op,
+		Time:   now().UTC(),
+	}
+	if op == OpSet {
+		if change.Version, err = tx.version(key, bucketName); err != nil {
+			return err
+		}
+	}
+	buf, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	cb := changesBucket(bucketName)
+	if err := tx.tx.createBucket(cb); err != nil {
+		return err
+	}
+	if err := tx.tx.put(seqKey(change.Seq), cb, buf); err != nil {
+		return err
+	}
+	if err := tx.tx.put(lastSeqKey, cb, []byte(seqKey(change.Seq))); err != nil {
+		return err
+	}
+	if keep := uint64(changesKeep.Int64()); keep > 0 && change.Seq > keep {
+		return tx.trimChanges(cb, change.Seq-keep)
+	}
+	return nil
+}
+
+// trimChanges delete changes of a change log bucket with seq up to last,
+// every old one is deleted so the log shrinks when `db.changes.keep` is lowered
+func (tx *Tx) trimChanges(cb string, last uint64) error {
+	v, err := tx.tx.get(firstSeqKey, cb)
+	if err != nil {
+		return err
+	}
+	var first uint64
+	if len(v) == 8 {
+		first = binary.BigEndian.Uint64(v)
+	} else {
+		// a log written before firstSeqKey existed, the first change is looked up once
+		c, err := tx.tx.cursor(cb)
+		if err != nil {
+			return err
+		}
+		k, _ := c.First()
+		if err := cursorErr(c); err != nil {
+			return err
+		}
+		if len(k) != 8 {
+			return nil
+		}
+		first = binary.BigEndian.Uint64(k)
+	}
+	if first > last {
+		return nil
+	}
+	for ; first <= last; first++ {
+		if err := tx.tx.delete(seqKey(first), cb); err != nil {
+			return err
+		}
+	}
+	return tx.tx.put(firstSeqKey, cb, []byte(seqKey(first)))
+}
+
+// resetChanges drop changes of a deleted bucket but keep its seq, so seq of
+// the recreated bucket continue. the delete take a seq which is not stored,
+// so every watcher is sent an OpGap and resync the bucket
+func (tx *Tx) resetChanges(bucketName string) error {
+	cb := changesBucket(bucketName)
+	ok, err := tx.tx.bucketExist(cb)
+	if err != nil || !ok {
+		return err
+	}
+	last, err := tx.LastChangeSeq(bucketName)
+	if err != nil {
+		return err
+	}
+	if err := tx.tx.deleteBucket(cb); err != nil {
+		return err
+	}
+	if err := tx.tx.createBucket(cb); err != nil {
+		return err
+	}
+	if err := tx.tx.put(lastSeqKey, cb, []byte(seqKey(last+1))); err != nil {
+		return err
+	}
+	return tx.tx.put(firstSeqKey, cb, []byte(seqKey(last+2)))
+}
+
+// TrimmedError is returned when the change after seq After is trimmed,
+// First is the oldest change kept in the log
+type TrimmedError struct {
+	Bucket string
+	After  uint64
+	First  uint64
+}
+
+func (e *TrimmedError) Error() string {
+	return fmt.Sprintf("%s: bucket `%s` has no change after %d, the oldest one is %d",
+		ErrChangesTrimmed, e.Bucket, e.After, e.First)
+}
+
+// Is make errors.Is(err, ErrChangesTrimmed) true
+func (e *TrimmedError) Is(target error) bool {
+	return target == ErrChangesTrimmed
+}
+
+// Changes return up to limit changes of bucket with seq greater than after,
+// a *TrimmedError is returned if some of them are trimmed from the log
+func (tx *Tx) Changes(bucketName string, after uint64, limit int) ([]Change, error) {
+	cb := changesBucket(bucketName)
+	ok, err := tx.tx.bucketExist(cb)
+	if err != nil || !ok {
+		return nil, err
+	}
+	c, err := tx.tx.cursor(cb)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	k, v := c.Seek([]byte(seqKey(after + 1)))
+	if len(k) == 8 {
+		if first := binary.BigEndian.Uint64(k); first > after+1 {
+			return nil, &TrimmedError{Bucket: bucketName, After: after, First: first}
+		}
+	} else if err := tx.checkTrimmed(bucketName, after); err != nil {
+		// the log has no change after `after`, but it may have lost some
+		// of them, like when the bucket is deleted
+		return nil, err
+	}
+	for ; k != nil && (limit <= 0 || len(changes) < limit); k, v = c.Next() {
+		if len(k) != 8 {
+			break
+		}
+		var change Change
+		if err := json.Unmarshal(v, &change); err != nil {
+			return nil, fmt.Errorf("change %x of bucket `%s` is corrupted: %s", k, bucketName, err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, cursorErr(c)
+}
+
+// checkTrimmed return a *TrimmedError if changes after seq after are
+// dropped from a change log which has no newer change
+func (tx *Tx) checkTrimmed(bucketName string, after uint64) error {
+	last, err := tx.LastChangeSeq(bucketName)
+	if err != nil || after >= last {
+		return err
+	}
+	v, err := tx.tx.get(firstSeqKey, changesBucket(bucketName))
+	if err != nil || len(v) != 8 {
+		return err
+	}
+	if first := binary.BigEndian.Uint64(v); first > after+1 {
+		return &TrimmedError{Bucket: bucketName, After: after, First: first}
+	}
+	return nil
+}
+
+// notifier wake watchers up after a write is committed, zero value is ready to use
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait return a channel closed by the next notify
+func (n *notifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+func (n *notifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// watch send changes of bucket with seq greater than after until ctx is done,
+// the channel is closed when watching stops. ErrChangesTrimmed is returned if
+// the log is already trimmed past after, changes trimmed while watching are
+// reported by an OpGap change
+func watch(ctx context.Context, s Store, n *notifier, bucketName string, after uint64) (<-chan Change, error) {
+	if !hasChangeLog(bucketName) {
+		return nil, fmt.Errorf("bucket `%s` has no change log", bucketName)
+	}
+	err := s.View(func(tx *Tx) error {
+		_, err := tx.Changes(bucketName, after, 1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan Change, watchBatch)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(watchPollInterval)
+		defer ticker.Stop()
+
+		for {
+			// wait before reading, so a write committed meanwhile is not missed
+			wake := n.wait()
+			var changes []Change
+			err := s.View(func(tx *Tx) error {
+				var err error
+				changes, err = tx.Changes(bucketName, after, watchBatch)
+				return err
+			})
+			var trimmed *TrimmedError
+			if errors.As(err, &trimmed) {
+				changes = []Change{{Seq: trimmed.First - 1, Bucket: bucketName, Op: OpGap, Time: now().UTC()}}
+			} else if err != nil {
+				log.Printf("watch bucket %s stopped: %s", bucketName, err)
+				return
+			}
+			for _, change := range changes {
+				select {
+				case ch <- change:
+					after = change.Seq
+				case <-ctx.Done():
+					return
+				}
+			}
+			if len(changes) == watchBatch || trimmed != nil {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch, nil
+}
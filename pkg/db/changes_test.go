@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func init() {
+	RegisterChangeLog("watched")
+}
+
+// receive return the next change of ch or fail after a timeout
+func receive(t *testing.T, ch <-chan Change) Change {
+	t.Helper()
+	select {
+	case change, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel is closed")
+		}
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change received")
+	}
+	return Change{}
+}
+
+func testStoreWatch(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "watched")
+	mustSet(t, s, "watched", "a", "1")
+	mustSet(t, s, "watched", "b", "2")
+	if err := s.Delete("a", "watched"); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []Change
+	err := s.View(func(tx *Tx) error {
+		var err error
+		changes, err = tx.Changes("watched", 0, 0)
+		return err
+	})
+	if err != nil || len(changes) != 3 {
+		t.Fatalf("expected 3 changes but got %v, %v", changes, err)
+	}
+	expected := []Change{
+		{Seq: 1, Bucket: "watched", Key: "a", Op: OpSet, Version: 1},
+		{Seq: 2, Bucket: "watched", Key: "b", Op: OpSet, Version: 1},
+		{Seq: 3, Bucket: "watched", Key: "a", Op: OpDelete},
+	}
+	for i, change := range changes {
+		change.Time = time.Time{}
+		if change != expected[i] {
+			t.Errorf("unexpected change %+v, expected %+v", change, expected[i])
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := s.Watch(ctx, "watched", 1)
+	if err != nil {
+		t.Fatalf("watch failed %s", err)
+	}
+	if c := receive(t, ch); c.Seq != 2 {
+		t.Errorf("expected change 2 but got %d", c.Seq)
+	}
+	if c := receive(t, ch); c.Seq != 3 {
+		t.Errorf("expected change 3 but got %d", c.Seq)
+	}
+	mustSet(t, s, "watched", "c", "3")
+	if c := receive(t, ch); c.Seq != 4 || c.Key != "c" {
+		t.Errorf("expected change 4 of c but got %+v", c)
+	}
+
+	// a watcher is sent a gap when the bucket is deleted, seq
+	// of the recreated bucket continue after it
+	if err := s.DeleteBucket("watched"); err != nil {
+		t.Fatal(err)
+	}
+	if c := receive(t, ch); c.Op != OpGap || c.Seq != 5 {
+		t.Errorf("expected gap 5 after delete bucket but got %+v", c)
+	}
+	if _, err := s.Watch(context.Background(), "watched", 4); !errors.Is(err, ErrChangesTrimmed) {
+		t.Errorf("watch from before delete bucket should fail with ErrChangesTrimmed, got %v", err)
+	}
+	mustCreateBucket(t, s, "watched")
+	mustSet(t, s, "watched", "d", "4")
+	if c := receive(t, ch); c.Seq != 6 || c.Key != "d" {
+		t.Errorf("expected change 6 of d but got %+v", c)
+	}
+
+	cancel()
+	for range ch {
+	}
+
+	mustCreateBucket(t, s, "test")
+	if _, err := s.Watch(context.Background(), "test", 0); err == nil {
+		t.Error("watch should fail for bucket without change log")
+	}
+}
+
+func TestChangeLogTrim(t *testing.T) {
+	s := NewMemory()
+	mustCreateBucket(t, s, "watched")
+	keep := int(changesKeep.Int64())
+	err := s.Update(func(tx *Tx) error {
+		for i := 0; i < keep+5; i++ {
+			if err := tx.Set(fmt.Sprintf("%d", i), "watched", []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = s.View(func(tx *Tx) error {
+		changes, err := tx.Changes("watched", 5, 0)
+		if err != nil || len(changes) != keep || changes[0].Seq != 6 {
+			t.Errorf("change log should be trimmed to last %d changes, got %d", keep, len(changes))
+		}
+		last, _ := tx.LastChangeSeq("watched")
+		if last != uint64(keep+5) {
+			t.Errorf("unexpected last seq %d", last)
+		}
+		return nil
+	})
+
+	// every old change is trimmed, like after `db.changes.keep` is lowered
+	err = s.Update(func(tx *Tx) error {
+		return tx.trimChanges(changesBucket("watched"), uint64(keep))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.View(func(tx *Tx) error {
+		changes, err := tx.Changes("watched", uint64(keep), 0)
+		if err != nil || len(changes) != 5 || changes[0].Seq != uint64(keep+1) {
+			t.Errorf("expected last 5 changes to be kept, got %d, %v", len(changes), err)
+		}
+		if last, _ := tx.LastChangeSeq("watched"); last != uint64(keep+5) {
+			t.Errorf("trim should keep last seq, got %d", last)
+		}
+		return nil
+	})
+}
+
+func TestWatchTrimmed(t *testing.T) {
+	s := NewMemory()
+	mustCreateBucket(t, s, "watched")
+	keep := int(changesKeep.Int64())
+	setMany := func(n int) {
+		t.Helper()
+		err := s.Update(func(tx *Tx) error {
+			for i := 0; i < n; i++ {
+				if err := tx.Set(fmt.Sprintf("%d", i), "watched", []byte("v")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	setMany(keep + 5)
+
+	if _, err := s.Watch(context.Background(), "watched", 2); !errors.Is(err, ErrChangesTrimmed) {
+		t.Errorf("watch should fail with ErrChangesTrimmed, got %v", err)
+	}
+	err := s.View(func(tx *Tx) error {
+		_, err := tx.Changes("watched", 0, 0)
+		return err
+	})
+	var trimmed *TrimmedError
+	if !errors.As(err, &trimmed) || trimmed.First != 6 {
+		t.Errorf("changes should fail with *TrimmedError, got %v", err)
+	}
+
+	// a watcher falling behind the trim receive a gap
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := s.Watch(ctx, "watched", uint64(keep+4))
+	if err != nil {
+		t.Fatalf("watch failed %s", err)
+	}
+	if c := receive(t, ch); c.Seq != uint64(keep+5) {
+		t.Fatalf("expected change %d but got %+v", keep+5, c)
+	}
+	// the watcher hold a full batch while the log is trimmed past it
+	setMany(watchBatch*2 + 1)
+	setMany(keep)
+	var gap Change
+	for gap.Op != OpGap {
+		gap = receive(t, ch)
+	}
+	last := uint64(2*keep + 5 + watchBatch*2 + 1)
+	if gap.Seq != last-uint64(keep) {
+		t.Errorf("expected gap up to %d but got %+v", last-uint64(keep), gap)
+	}
+	if c := receive(t, ch); c.Seq != gap.Seq+1 {
+		t.Errorf("expected change %d after the gap but got %+v", gap.Seq+1, c)
+	}
+}
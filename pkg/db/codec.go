@@ -0,0 +1,200 @@
+package db
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encode values written by SetJson and decode values read by GetJson
+// and the other Json helpers. values of a codec with a non zero tag are
+// stored with the tag as their first byte, so values of different codecs
+// can live in one bucket and a bucket can be moved to another codec
+type Codec interface {
+	// Name identify the codec, like `json`
+	Name() string
+	// Tag is the byte stored before encoded values, it should be in
+	// [MinCodecTag, MaxCodecTag] which never start a JSON document.
+	// JSON has no tag, so plain JSON values stay readable by other tools
+	Tag() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// range of codec tags, they are control characters JSON can not start with
+const (
+	MinCodecTag byte = 0x01
+	MaxCodecTag byte = 0x08
+)
+
+// built-in codecs
+var (
+	JSON     Codec = jsonCodec{}
+	Gob      Codec = gobCodec{}
+	MsgPack  Codec = msgpackCodec{}
+	Protobuf Codec = protobufCodec{}
+)
+
+var (
+	codecsMu     sync.RWMutex
+	codecsByTag  = map[byte]Codec{Gob.Tag(): Gob, MsgPack.Tag(): MsgPack, Protobuf.Tag(): Protobuf}
+	bucketCodecs = make(map[string]Codec)
+)
+
+// RegisterCodec make codec the encoding of values written by the Json helpers
+// in bucket, values already stored are still decoded with their own codec.
+// it will panic if codec tag is out of range or is used by another codec
+func RegisterCodec(bucketName string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if tag := codec.Tag(); tag != 0 {
+		if tag < MinCodecTag || tag > MaxCodecTag {
+			panic(fmt.Sprintf("db: codec %s tag %#x is out of range", codec.Name(), tag))
+		}
+		if c, ok := codecsByTag[tag]; ok && c.Name() != codec.Name() {
+			panic(fmt.Sprintf("db: codec %s tag %#x is used by %s", codec.Name(), tag, c.Name()))
+		}
+		codecsByTag[tag] = codec
+	}
+	bucketCodecs[bucketName] = codec
+}
+
+// BucketCodec return the codec values of bucket are written with, JSON by default
+func BucketCodec(bucketName string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	if c, ok := bucketCodecs[bucketName]; ok {
+		return c
+	}
+	return JSON
+}
+
+// encodeValue encode v with codec of bucket, prefixed by the codec tag
+func encodeValue(bucketName string, v interface{}) ([]byte, error) {
+	codec := BucketCodec(bucketName)
+	buf, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if tag := codec.Tag(); tag != 0 {
+		buf = append([]byte{tag}, buf...)
+	}
+	return buf, nil
+}
+
+// decodeValue decode data into v with the codec its tag name,
+// values without tag are JSON
+func decodeValue(data []byte, v interface{}) error {
+	if len(data) > 0 && data[0] >= MinCodecTag && data[0] <= MaxCodecTag {
+		codecsMu.RLock()
+		codec, ok := codecsByTag[data[0]]
+		codecsMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown codec tag %#x", data[0])
+		}
+		return codec.Unmarshal(data[1:], v)
+	}
+	return JSON.Unmarshal(data, v)
+}
+
+// Recode rewrite values of bucket with its current codec, values are decoded
+// into a new value of model type. record versions are kept as they are,
+// it is meant to be used in a migration after the bucket codec is changed
+func (tx *Tx) Recode(bucketName string, model interface{}) (int, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+	data, err := tx.GetAll(bucketName)
+	if err != nil {
+		return 0, err
+	}
+	t := reflect.TypeOf(model)
+	for _, kVal := range data {
+		record := reflect.New(t).Interface()
+		if err := decodeValue(kVal.Val, record); err != nil {
+			return 0, fmt.Errorf("decode key `%s` failed: %s", kVal.Key, err)
+		}
+		buf, err := encodeValue(bucketName, record)
+		if err != nil {
+			return 0, fmt.Errorf("encode key `%s` failed: %s", kVal.Key, err)
+		}
+		// indexed fields are not changed, so only the raw value is replaced
+		if err := tx.putValue(kVal.Key, bucketName, buf); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+func (jsonCodec) Tag() byte    { return 0 }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+func (gobCodec) Tag() byte    { return 0x01 }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+func (msgpackCodec) Tag() byte    { return 0x02 }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// protobufCodec store values which implement proto.Message
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+func (protobufCodec) Tag() byte    { return 0x03 }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not a proto message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecUser struct {
+	Name  string
+	Email string
+	Age   int
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{JSON, Gob, MsgPack} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			bucket := "codec-" + codec.Name()
+			RegisterCodec(bucket, codec)
+
+			s := NewMemory()
+			mustCreateBucket(t, s, bucket)
+			// zero fields are not written by gob, they should not leak between records of a list
+			users := []codecUser{{"a", "a@test.com", 1}, {"b", "", 0}}
+			for _, u := range users {
+				if err := s.SetJson(u.Name, bucket, u); err != nil {
+					t.Fatalf("set failed %s", err)
+				}
+			}
+
+			raw, _ := s.GetOne("a", bucket)
+			if codec.Tag() != 0 && raw[0] != codec.Tag() {
+				t.Errorf("value should start with codec tag %#x but got %#x", codec.Tag(), raw[0])
+			}
+
+			var user codecUser
+			if err := s.GetJson("a", bucket, &user); err != nil || user != users[0] {
+				t.Errorf("unexpected user %v, %v", user, err)
+			}
+			var list []codecUser
+			if err := s.GetJsonList(bucket, &list); err != nil || !reflect.DeepEqual(list, users) {
+				t.Errorf("unexpected users %v, %v", list, err)
+			}
+		})
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	bucket := "codec-protobuf"
+	RegisterCodec(bucket, Protobuf)
+
+	s := NewMemory()
+	mustCreateBucket(t, s, bucket)
+	if err := s.SetJson("a", bucket, wrapperspb.String("hello")); err != nil {
+		t.Fatalf("set failed %s", err)
+	}
+	ret := &wrapperspb.StringValue{}
+	if err := s.GetJson("a", bucket, ret); err != nil || !proto.Equal(ret, wrapperspb.String("hello")) {
+		t.Errorf("unexpected message %v, %v", ret, err)
+	}
+	if err := s.SetJson("b", bucket, codecUser{}); err == nil {
+		t.Error("protobuf codec should reject non proto values")
+	}
+}
+
+func TestRecode(t *testing.T) {
+	bucket := "codec-recode"
+	s := NewMemory()
+	mustCreateBucket(t, s, bucket)
+	if err := s.SetJson("a", bucket, codecUser{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// values written before the codec change stay readable
+	RegisterCodec(bucket, MsgPack)
+	if err := s.SetJson("b", bucket, codecUser{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	var list []codecUser
+	if err := s.GetJsonList(bucket, &list); err != nil || len(list) != 2 || list[0].Name != "a" {
+		t.Fatalf("mixed codecs should be readable %v, %v", list, err)
+	}
+
+	err := s.Update(func(tx *Tx) error {
+		n, err := tx.Recode(bucket, codecUser{})
+		if n != 2 {
+			t.Errorf("expected 2 records recoded but got %d", n)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("recode failed %s", err)
+	}
+	raw, _ := s.GetOne("a", bucket)
+	if raw[0] != MsgPack.Tag() {
+		t.Errorf("recoded value should use msgpack, got %q", raw)
+	}
+	if v, _ := s.Version("a", bucket); v != 1 {
+		t.Errorf("recode should keep record version, got %d", v)
+	}
+}
+
+func TestRegisterCodecTag(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("register a codec with a used tag should panic")
+		}
+	}()
+	RegisterCodec("codec-dup", fakeCodec{})
+}
+
+type fakeCodec struct{ jsonCodec }
+
+func (fakeCodec) Name() string { return "fake" }
+func (fakeCodec) Tag() byte    { return Gob.Tag() }
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// sequenceBucket hold the last key given by Sequence to each bucket
+const sequenceBucket = internalPrefix + "sequence"
+
+// IDGenerator return the key of a new record of bucket, it runs in the
+// transaction the record is inserted in
+type IDGenerator func(tx *Tx, bucketName string) (string, error)
+
+// UUIDs generate random UUID keys, it is the default of collections
+func UUIDs() IDGenerator {
+	return func(tx *Tx, bucketName string) (string, error) {
+		return uuid.New().String(), nil
+	}
+}
+
+// Sequence generate increasing keys padded to 20 digits, so records of
+// the bucket are ordered by insertion. deleted keys are not reused
+func Sequence() IDGenerator {
+	return func(tx *Tx, bucketName string) (string, error) {
+		if err := tx.checkWritable(); err != nil {
+			return "", err
+		}
+		if err := tx.tx.createBucket(sequenceBucket); err != nil {
+			return "", err
+		}
+		var last uint64
+		v, err := tx.tx.get(bucketName, sequenceBucket)
+		if err != nil {
+			return "", err
+		}
+		if len(v) == 8 {
+			last = binary.BigEndian.Uint64(v)
+		}
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, last+1)
+		if err := tx.tx.put(bucketName, sequenceBucket, buf); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%020d", last+1), nil
+	}
+}
+
+// Hook is called with records of a collection and their key, an error
+// abort the operation and its transaction
+type Hook[T any] func(tx *Tx, key string, record *T) error
+
+// CollectionOption configure a collection created by NewCollection
+type CollectionOption[T any] func(c *Collection[T])
+
+// WithIDGenerator set how keys of inserted records are generated
+func WithIDGenerator[T any](gen IDGenerator) CollectionOption[T] {
+	return func(c *Collection[T]) {
+		c.newID = gen
+	}
+}
+
+// BeforeSave add a hook called before a record is written, it can
+// change the record, e.g. to store its key in it
+func BeforeSave[T any](hook Hook[T]) CollectionOption[T] {
+	return func(c *Collection[T]) {
+		c.beforeSave = append(c.beforeSave, hook)
+	}
+}
+
+// AfterLoad add a hook called after a record is read, and after it
+// is written so the returned record look like a loaded one
+func AfterLoad[T any](hook Hook[T]) CollectionOption[T] {
+	return func(c *Collection[T]) {
+		c.afterLoad = append(c.afterLoad, hook)
+	}
+}
+
+// Collection is a typed view of a bucket, records are encoded with the
+// bucket codec like SetJson. missing records are reported with ErrNotFound
+type Collection[T any] struct {
+	store      Store
+	bucketName string
+	newID      IDGenerator
+	beforeSave []Hook[T]
+	afterLoad  []Hook[T]
+}
+
+// NewCollection return a collection of records of type T stored in bucket,
+// the bucket should exist
+func NewCollection[T any](store Store, bucketName string, opts ...CollectionOption[T]) *Collection[T] {
+	c := &Collection[T]{store: store, bucketName: bucketName, newID: UUIDs()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Store return the store of collection
+func (c *Collection[T]) Store() Store {
+	return c.store
+}
+
+// BucketName return the bucket of collection
+func (c *Collection[T]) BucketName() string {
+	return c.bucketName
+}
+
+func (c *Collection[T]) runHooks(hooks []Hook[T], tx *Tx, key string, record *T) error {
+	for _, hook := range hooks {
+		if err := hook(tx, key, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Collection[T]) decode(tx *Tx, key string, value []byte) (T, error) {
+	var record T
+	if err := decodeValue(value, &record); err != nil {
+		return record, fmt.Errorf("decode key `%s` of bucket `%s` failed: %s", key, c.bucketName, err)
+	}
+	return record, c.runHooks(c.afterLoad, tx, key, &record)
+}
+
+// save write record, version nil mean the write is unconditional
+func (c *Collection[T]) save(tx *Tx, key string, record T, version *uint64) (T, error) {
+	if err := c.runHooks(c.beforeSave, tx, key, &record); err != nil {
+		return record, err
+	}
+	var err error
+	if version != nil {
+		err = tx.SetJsonIfVersion(key, c.bucketName, record, *version)
+	} else {
+		err = tx.SetJson(key, c.bucketName, record)
+	}
+	if err != nil {
+		return record, err
+	}
+	return record, c.runHooks(c.afterLoad, tx, key, &record)
+}
+
+// mustExist return ErrNotFound if key not exist
+func (c *Collection[T]) mustExist(tx *Tx, key string) error {
+	ok, err := tx.IsExist(key, c.bucketName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return keyNotExist(key, c.bucketName)
+	}
+	return nil
+}
+
+// Insert add record with a new key, the saved record is returned.
+// concurrent inserts are committed together, see Store.Batch
+func (c *Collection[T]) Insert(record T) (key string, saved T, err error) {
+	err = c.store.Batch(func(tx *Tx) error {
+		if key, err = c.newID(tx, c.bucketName); err != nil {
+			return err
+		}
+		// version zero make sure an existing record is not overwritten
+		var version uint64
+		saved, err = c.save(tx, key, record, &version)
+		return err
+	})
+	return key, saved, err
+}
+
+// InsertMany add records with new keys in transactions of `db.batch.chunk`
+// records, the saved records are returned. records of committed
+// transactions are returned with the error if a later one fails
+func (c *Collection[T]) InsertMany(records []T) ([]T, error) {
+	saved := make([]T, 0, len(records))
+	// records of a chunk are pending until its transaction is committed,
+	// a chunk is written only after the previous one is committed
+	var pending []T
+	_, err := writeChunks(c.store, records, int(batchChunk.Int64()), func(tx *Tx, chunk []T) error {
+		saved = append(saved, pending...)
+		pending = make([]T, 0, len(chunk))
+		for _, record := range chunk {
+			key, err := c.newID(tx, c.bucketName)
+			if err != nil {
+				return err
+			}
+			var version uint64
+			record, err = c.save(tx, key, record, &version)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return saved, err
+	}
+	return append(saved, pending...), nil
+}
+
+// Get return record of key
+func (c *Collection[T]) Get(key string) (T, error) {
+	var record T
+	err := c.store.View(func(tx *Tx) error {
+		value, err := tx.GetOne(key, c.bucketName)
+		if err != nil {
+			return err
+		}
+		record, err = c.decode(tx, key, value)
+		return err
+	})
+	return record, err
+}
+
+// Update replace record of key, it should exist
+func (c *Collection[T]) Update(key string, record T) (T, error) {
+	return c.update(key, record, nil)
+}
+
+// UpdateIfVersion replace record of key only if it is still in
+// the given version, a *ConflictError is returned otherwise
+func (c *Collection[T]) UpdateIfVersion(key string, record T, version uint64) (T, error) {
+	return c.update(key, record, &version)
+}
+
+func (c *Collection[T]) update(key string, record T, version *uint64) (T, error) {
+	// check and write in one transaction so a concurrent delete is not overwritten
+	err := c.store.Update(func(tx *Tx) error {
+		if err := c.mustExist(tx, key); err != nil {
+			return err
+		}
+		var err error
+		record, err = c.save(tx, key, record, version)
+		return err
+	})
+	return record, err
+}
+
+// Delete remove record of key, it should exist
+func (c *Collection[T]) Delete(key string) error {
+	return c.store.Update(func(tx *Tx) error {
+		if err := c.mustExist(tx, key); err != nil {
+			return err
+		}
+		return tx.Delete(key, c.bucketName)
+	})
+}
+
+// DeleteIfVersion remove record of key only if it is still in
+// the given version, a *ConflictError is returned otherwise
+func (c *Collection[T]) DeleteIfVersion(key string, version uint64) error {
+	return c.store.Update(func(tx *Tx) error {
+		if err := c.mustExist(tx, key); err != nil {
+			return err
+		}
+		return tx.DeleteIfVersion(key, c.bucketName, version)
+	})
+}
+
+// Scan call fn for records one by one, see Tx.Scan
+func (c *Collection[T]) Scan(opts ScanOptions, fn func(key string, record T) error) (next string, err error) {
+	err = c.store.View(func(tx *Tx) error {
+		next, err = tx.Scan(c.bucketName, opts, func(item Item) error {
+			record, err := c.decode(tx, item.Key, item.Value)
+			if err != nil {
+				return err
+			}
+			return fn(item.Key, record)
+		})
+		return err
+	})
+	return next, err
+}
+
+// List return up to limit records ordered by key starting after cursor,
+// next is the cursor of the following page and is empty on the last page.
+// zero limit return all records
+func (c *Collection[T]) List(cursor string, limit int) (records []T, next string, err error) {
+	opts := ScanOptions{After: cursor, Limit: limit}
+	next, err = c.Scan(opts, func(key string, record T) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return records, next, nil
+}
+
+// Count return the number of records, expired records are not counted
+func (c *Collection[T]) Count() (int, error) {
+	count := 0
+	err := c.store.View(func(tx *Tx) error {
+		cur, err := tx.Cursor(c.bucketName)
+		if err != nil {
+			return err
+		}
+		for k, _ := cur.First(); k != nil; k, _ = cur.Next() {
+			count++
+		}
+		return cursorErr(cur)
+	})
+	return count, err
+}
+
+// Find return records with value in the given index of bucket
+func (c *Collection[T]) Find(indexName, value string) ([]T, error) {
+	var records []T
+	err := c.store.View(func(tx *Tx) error {
+		data, err := tx.FindByIndex(c.bucketName, indexName, value)
+		if err != nil {
+			return err
+		}
+		for _, kVal := range data {
+			record, err := c.decode(tx, kVal.Key, kVal.Val)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
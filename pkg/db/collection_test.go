@@ -0,0 +1,194 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type note struct {
+	ID    string `json:"id"`
+	Text  string `json:"text"`
+	Saves int    `json:"saves"`
+	// Loaded is set by the after-load hook and is not stored
+	Loaded bool `json:"-"`
+}
+
+func newNotes(t *testing.T, opts ...CollectionOption[note]) *Collection[note] {
+	s := NewMemory()
+	mustCreateBucket(t, s, "notes")
+	opts = append(opts,
+		BeforeSave(func(tx *Tx, key string, n *note) error {
+			if n.Text == "" {
+				return errors.New("text is required")
+			}
+			n.ID = key
+			n.Saves++
+			return nil
+		}),
+		AfterLoad(func(tx *Tx, key string, n *note) error {
+			n.Loaded = true
+			return nil
+		}),
+	)
+	return NewCollection(s, "notes", opts...)
+}
+
+func TestCollection(t *testing.T) {
+	notes := newNotes(t)
+
+	key, saved, err := notes.Insert(note{Text: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ID != key || saved.Saves != 1 || !saved.Loaded {
+		t.Errorf("hooks should run on insert, got %+v", saved)
+	}
+	if _, _, err := notes.Insert(note{}); err == nil {
+		t.Error("insert should fail when before-save hook fails")
+	}
+
+	got, err := notes.Get(key)
+	if err != nil || got.Text != "a" || got.ID != key || !got.Loaded {
+		t.Fatalf("unexpected record %+v, %v", got, err)
+	}
+	if _, err := notes.Get("not-exist"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get should fail with ErrNotFound, got %v", err)
+	}
+
+	got.Text = "b"
+	updated, err := notes.Update(key, got)
+	if err != nil || updated.Text != "b" || updated.Saves != 2 {
+		t.Fatalf("unexpected updated record %+v, %v", updated, err)
+	}
+	if _, err := notes.Update("not-exist", got); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update should not create records, got %v", err)
+	}
+	if _, err := notes.UpdateIfVersion(key, got, 1); !errors.Is(err, ErrConflict) {
+		t.Errorf("stale update should fail with ErrConflict, got %v", err)
+	}
+	if _, err := notes.UpdateIfVersion(key, got, 2); err != nil {
+		t.Errorf("update in current version should succeed, got %v", err)
+	}
+
+	if n, err := notes.Count(); err != nil || n != 1 {
+		t.Errorf("expected 1 record but got %d, %v", n, err)
+	}
+	if err := notes.DeleteIfVersion(key, 1); !errors.Is(err, ErrConflict) {
+		t.Errorf("stale delete should fail with ErrConflict, got %v", err)
+	}
+	if err := notes.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := notes.Delete(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete should fail with ErrNotFound, got %v", err)
+	}
+	if n, err := notes.Count(); err != nil || n != 0 {
+		t.Errorf("expected no record but got %d, %v", n, err)
+	}
+}
+
+func TestCollection_ListSequence(t *testing.T) {
+	notes := newNotes(t, WithIDGenerator[note](Sequence()))
+
+	texts := []string{"a", "b", "c", "d", "e"}
+	for i, text := range texts {
+		key, _, err := notes.Insert(note{Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "0000000000000000000" + string(rune('1'+i)); key != expected {
+			t.Errorf("expected key %s but got %s", expected, key)
+		}
+	}
+	if err := notes.Delete("00000000000000000005"); err != nil {
+		t.Fatal(err)
+	}
+	// deleted keys are not reused
+	if key, _, _ := notes.Insert(note{Text: "f"}); key != "00000000000000000006" {
+		t.Errorf("expected key 6 but got %s", key)
+	}
+
+	var all []string
+	cursor := ""
+	for {
+		page, next, err := notes.List(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range page {
+			if !n.Loaded {
+				t.Error("after-load hook should run on list")
+			}
+			all = append(all, n.Text)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(all) != 5 || all[0] != "a" || all[4] != "f" {
+		t.Errorf("unexpected list %v", all)
+	}
+}
+
+func TestCollection_Find(t *testing.T) {
+	s := NewMemory()
+	mustCreateBucket(t, s, "indexed")
+	notes := NewCollection[note](s, "indexed")
+	RegisterIndex("indexed", JsonIndex("text", note{}, func(r interface{}) []string {
+		return []string{r.(*note).Text}
+	}))
+
+	for _, text := range []string{"x", "y", "x"} {
+		if _, _, err := notes.Insert(note{Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := notes.Find("text", "x")
+	if err != nil || len(found) != 2 || found[0].Text != "x" {
+		t.Errorf("expected 2 records but got %v, %v", found, err)
+	}
+}
+
+func TestCollection_InsertMany(t *testing.T) {
+	notes := newNotes(t, WithIDGenerator[note](Sequence()))
+
+	saved, err := notes.InsertMany([]note{{Text: "a"}, {Text: "b"}, {Text: "c"}})
+	if err != nil || len(saved) != 3 {
+		t.Fatalf("expected 3 saved notes but got %d, %v", len(saved), err)
+	}
+	for i, n := range saved {
+		if n.ID != fmt.Sprintf("%020d", i+1) || !n.Loaded {
+			t.Errorf("unexpected saved note %+v", n)
+		}
+	}
+
+	// the failed note abort its chunk
+	saved, err = notes.InsertMany([]note{{Text: "d"}, {}})
+	if err == nil || len(saved) != 0 {
+		t.Errorf("expected error and no saved notes but got %d, %v", len(saved), err)
+	}
+	if count, _ := notes.Count(); count != 3 {
+		t.Errorf("expected 3 notes but got %d", count)
+	}
+
+	// records of a transaction which fail to commit are not returned
+	notes.store = failedCommit{notes.store}
+	saved, err = notes.InsertMany([]note{{Text: "e"}})
+	if err == nil || len(saved) != 0 {
+		t.Errorf("expected error and no saved notes but got %d, %v", len(saved), err)
+	}
+}
+
+// failedCommit is a store whose updates fail after fn return
+type failedCommit struct {
+	Store
+}
+
+func (s failedCommit) Update(fn func(tx *Tx) error) error {
+	if err := s.Store.Update(fn); err != nil {
+		return err
+	}
+	return errors.New("commit failed")
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression compress values of a bucket before they are stored. compressed
+// values start with the compression tag, so compressed and plain values can
+// live in one bucket and compression can be turned on or off at any time,
+// see framedBucket
+type Compression interface {
+	// Name identify the compression, like `gzip`
+	Name() string
+	// Tag is the byte stored before compressed values, it
+	// should be in [MinCompressionTag, MaxCompressionTag]
+	Tag() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// range of compression tags, they do not collide with codec tags
+const (
+	MinCompressionTag byte = 0x10
+	MaxCompressionTag byte = 0x17
+)
+
+// minCompressSize is the size values smaller than it are stored as they are,
+// compressing them costs more than it saves
+const minCompressSize = 64
+
+// built-in compressions
+var (
+	Snappy Compression = snappyCompression{}
+	Zstd   Compression = zstdCompression{}
+	Gzip   Compression = gzipCompression{}
+)
+
+var (
+	compressionsMu     sync.RWMutex
+	compressionsByTag  = map[byte]Compression{Snappy.Tag(): Snappy, Zstd.Tag(): Zstd, Gzip.Tag(): Gzip}
+	bucketCompressions = make(map[string]Compression)
+)
+
+// RegisterCompression compress values written to bucket from now on, it
+// will panic if compression tag is out of range or is used by another one
+func RegisterCompression(bucketName string, c Compression) {
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+
+	tag := c.Tag()
+	if tag < MinCompressionTag || tag > MaxCompressionTag {
+		panic(fmt.Sprintf("db: compression %s tag %#x is out of range", c.Name(), tag))
+	}
+	if other, ok := compressionsByTag[tag]; ok && other.Name() != c.Name() {
+		panic(fmt.Sprintf("db: compression %s tag %#x is used by %s", c.Name(), tag, other.Name()))
+	}
+	compressionsByTag[tag] = c
+	bucketCompressions[bucketName] = c
+}
+
+// BucketCompression return compression of bucket, nil if values are not compressed
+func BucketCompression(bucketName string) Compression {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	return bucketCompressions[bucketName]
+}
+
+// compress compress value with bucket compression, value is returned
+// escaped when bucket is not compressed or compression does not help
+func compress(bucketName string, value []byte) ([]byte, error) {
+	c := BucketCompression(bucketName)
+	if c == nil || len(value) < minCompressSize {
+		return escape(value), nil
+	}
+	buf, err := c.Compress(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s compress failed: %s", c.Name(), err)
+	}
+	if len(buf)+1 >= len(value) {
+		return escape(value), nil
+	}
+	return append([]byte{c.Tag()}, buf...), nil
+}
+
+// decompress return the plain value of a framed value
+func decompress(value []byte) ([]byte, error) {
+	if len(value) > 0 && value[0] == escapeTag {
+		return value[1:], nil
+	}
+	if len(value) == 0 || value[0] < MinCompressionTag || value[0] > MaxCompressionTag {
+		return value, nil
+	}
+	compressionsMu.RLock()
+	c, ok := compressionsByTag[value[0]]
+	compressionsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown compression tag %#x", value[0])
+	}
+	buf, err := c.Decompress(value[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s decompress failed: %s", c.Name(), err)
+	}
+	return buf, nil
+}
+
+type snappyCompression struct{}
+
+func (snappyCompression) Name() string { return "snappy" }
+func (snappyCompression) Tag() byte    { return 0x11 }
+
+func (snappyCompression) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompression) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+// zstd encoder and decoder are safe for concurrent EncodeAll and DecodeAll
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+type zstdCompression struct{}
+
+func (zstdCompression) Name() string { return "zstd" }
+func (zstdCompression) Tag() byte    { return 0x12 }
+
+func (zstdCompression) Compress(data []byte) ([]byte, error) {
+	if err := initZstd(); err != nil {
+		return nil, err
+	}
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCompression) Decompress(data []byte) ([]byte, error) {
+	if err := initZstd(); err != nil {
+		return nil, err
+	}
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+type gzipCompression struct{}
+
+func (gzipCompression) Name() string { return "gzip" }
+func (gzipCompression) Tag() byte    { return 0x13 }
+
+func (gzipCompression) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompression) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// largeValue return a task like json value of about size bytes
+func largeValue(size int) []byte {
+	buf, _ := json.Marshal(map[string]string{
+		"title":       "compress me",
+		"description": strings.Repeat("a long task description ", size/24),
+	})
+	return buf
+}
+
+// setCompression turn compression of bucket on, or off when c is nil
+func setCompression(bucketName string, c Compression) {
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+	if c == nil {
+		delete(bucketCompressions, bucketName)
+		return
+	}
+	bucketCompressions[bucketName] = c
+}
+
+// testStoreReservedBytes check plain values which start with a header byte
+// are stored as they are, with and without compression of their bucket
+func testStoreReservedBytes(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	defer setCompression("test", nil)
+
+	values := make(map[string][]byte)
+	check := func(state string) {
+		t.Helper()
+		for key, value := range values {
+			got, err := s.GetOne(key, "test")
+			if err != nil || !bytes.Equal(got, value) {
+				t.Errorf("%s: unexpected value of %s, %q, %v", state, key, got, err)
+			}
+		}
+		all, err := s.GetAll("test")
+		if err != nil || len(all) != len(values) {
+			t.Errorf("%s: unexpected values %d, %v", state, len(all), err)
+		}
+	}
+	setReserved := func(prefix string) {
+		t.Helper()
+		for b := MinCompressionTag; b <= escapeTag; b++ {
+			key := fmt.Sprintf("%s-%#x", prefix, b)
+			values[key] = []byte{b, 'h', 'i'}
+			if err := s.Set(key, "test", values[key]); err != nil {
+				t.Fatalf("set %s failed %s", key, err)
+			}
+		}
+	}
+
+	setReserved("plain")
+	check("plain bucket")
+	// overwrite decode the old value first
+	setReserved("plain")
+	check("plain bucket")
+	err := s.View(func(tx *Tx) error {
+		raw, _ := tx.tx.get("plain-0x11", "test")
+		if !bytes.Equal(raw, values["plain-0x11"]) {
+			t.Errorf("values of a plain bucket should be stored as they are, got %q", raw)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setCompression("test", Snappy)
+	values["large"] = largeValue(1024)
+	mustSet(t, s, "test", "large", string(values["large"]))
+	setReserved("compressed")
+	check("compressed bucket")
+
+	// compressed values stay readable after compression is turned off
+	setCompression("test", nil)
+	setReserved("uncompressed")
+	check("uncompressed bucket")
+}
+
+func TestCompression(t *testing.T) {
+	value := largeValue(4096)
+	for _, c := range []Compression{Snappy, Zstd, Gzip} {
+		t.Run(c.Name(), func(t *testing.T) {
+			bucket := "compress-" + c.Name()
+			s := NewMemory()
+			mustCreateBucket(t, s, bucket)
+
+			// values written before compression is enabled stay readable
+			mustSet(t, s, bucket, "plain", string(value))
+			RegisterCompression(bucket, c)
+			mustSet(t, s, bucket, "compressed", string(value))
+			mustSet(t, s, bucket, "small", "tiny")
+
+			err := s.View(func(tx *Tx) error {
+				raw, _ := tx.tx.get("compressed", bucket)
+				if raw[0] != c.Tag() || len(raw) >= len(value) {
+					t.Errorf("value is not compressed, %d bytes", len(raw))
+				}
+				raw, _ = tx.tx.get("small", bucket)
+				if string(raw) != "tiny" {
+					t.Errorf("small value should be stored as it is, got %q", raw)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, key := range []string{"compressed", "plain"} {
+				v, err := s.GetOne(key, bucket)
+				if err != nil || !bytes.Equal(v, value) {
+					t.Errorf("unexpected value of %s, %v", key, err)
+				}
+			}
+			all, err := s.GetAll(bucket)
+			if err != nil || len(all) != 3 || !bytes.Equal(all[0].Val, value) {
+				t.Errorf("unexpected values %d, %v", len(all), err)
+			}
+		})
+	}
+}
+
+func TestCompressionIndexAndCopy(t *testing.T) {
+	bucket := "compress-index"
+	RegisterCompression(bucket, Snappy)
+	RegisterIndex(bucket, JsonIndex("title", map[string]string{}, func(r interface{}) []string {
+		return []string{(*r.(*map[string]string))["title"]}
+	}))
+
+	s := NewMemory()
+	mustCreateBucket(t, s, bucket)
+	value := largeValue(1024)
+	mustSet(t, s, bucket, "a", string(value))
+
+	found, err := s.FindByIndex(bucket, "title", "compress me")
+	if err != nil || len(found) != 1 || !bytes.Equal(found[0].Val, value) {
+		t.Errorf("index should work on compressed values %v", err)
+	}
+	if err := s.Delete("a", bucket); err != nil {
+		t.Fatalf("delete compressed value failed %s", err)
+	}
+	if found, _ := s.FindByIndex(bucket, "title", "compress me"); len(found) != 0 {
+		t.Error("index entry should be removed with compressed value")
+	}
+
+	mustSet(t, s, bucket, "b", string(value))
+	dst := NewMemory()
+	if err := Copy(dst, s); err != nil {
+		t.Fatal(err)
+	}
+	_ = dst.View(func(tx *Tx) error {
+		raw, _ := tx.tx.get("b", bucket)
+		if raw[0] != Snappy.Tag() {
+			t.Error("copy should keep values compressed")
+		}
+		return nil
+	})
+}
+
+func BenchmarkCompression(b *testing.B) {
+	value := largeValue(4096)
+	for _, c := range []Compression{nil, Snappy, Zstd, Gzip} {
+		name := "none"
+		if c != nil {
+			name = c.Name()
+		}
+		b.Run(name, func(b *testing.B) {
+			bucket := "bench-compress-" + name
+			if c != nil {
+				RegisterCompression(bucket, c)
+			}
+			s := NewMemory()
+			if err := s.CreateBucket(bucket); err != nil {
+				b.Fatal(err)
+			}
+
+			var stored int
+			_ = s.Update(func(tx *Tx) error {
+				buf, _ := encodeStored(bucket, "key", value)
+				stored = len(buf)
+				return nil
+			})
+			b.SetBytes(int64(len(value)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				key := fmt.Sprintf("%d", i%100)
+				if err := s.Set(key, bucket, value); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := s.GetOne(key, bucket); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(stored), "stored-bytes")
+		})
+	}
+}
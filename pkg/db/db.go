@@ -1,9 +1,9 @@
 package db
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"reflect"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
@@ -15,14 +15,31 @@ type KeyVal struct {
 
 const ContextKey = "db"
 
+// BoltBackend is the name bbolt store is registered with
+const BoltBackend = "bolt"
+
 // Service will hold bbolt db and its settings
 type Service struct {
-	path string
-	DB   *bbolt.DB
+	path     string
+	DB       *bbolt.DB
+	changes  notifier
+	readOnly readOnlyFlag
 }
 
-func New(path string) (*Service, error) {
-	db, err := bbolt.Open(path, 0666, nil)
+var _ Store = (*Service)(nil)
+
+// New open or create a bbolt file in the given path, without options it
+// wait for the file lock forever. ErrLockTimeout is returned if the lock
+// is not acquired in the time set by WithTimeout
+func New(path string, opts ...Option) (*Service, error) {
+	o := options{mode: 0666}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	db, err := bbolt.Open(path, o.mode, &o.bolt)
+	if err == bbolt.ErrTimeout {
+		return nil, fmt.Errorf("open %s: %w", path, ErrLockTimeout)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -36,163 +53,328 @@ func (s *Service) Close() error {
 	return s.DB.Close()
 }
 
-func (s *Service) CreateBucket(bucketName string) (*bbolt.Bucket, error) {
-	var b *bbolt.Bucket
-	var err error
-	err = s.DB.Update(func(tx *bbolt.Tx) error {
-		b, err = tx.CreateBucketIfNotExists([]byte(bucketName))
-		if err != nil {
-			return fmt.Errorf("create bucket: `%s`", err)
-		}
-		return nil
+// ReadOnly return true if writes are rejected with ErrReadOnly,
+// because of maintenance mode or opening the file read-only
+func (s *Service) ReadOnly() bool {
+	return s.readOnly.get() || s.DB.IsReadOnly()
+}
+
+// SetReadOnly switch maintenance read-only mode, a file opened
+// read-only can not be made writable
+func (s *Service) SetReadOnly(readOnly bool) error {
+	if !readOnly && s.DB.IsReadOnly() {
+		return ErrReadOnly
+	}
+	s.readOnly.set(readOnly)
+	return nil
+}
+
+func (s *Service) Update(fn func(tx *Tx) error) error {
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
+	err := s.DB.Update(func(tx *bbolt.Tx) error {
+		return fn(&Tx{tx: boltTx{tx: tx}, writable: true})
+	})
+	if err == nil {
+		s.changes.notify()
+	}
+	return err
+}
+
+// Batch run fn in a read-write transaction shared with concurrent Batch
+// calls, so writes of many goroutines are committed together. fn may run
+// more than once if another fn of the batch fails, so it should be idempotent
+func (s *Service) Batch(fn func(tx *Tx) error) error {
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
+	err := s.DB.Batch(func(tx *bbolt.Tx) error {
+		return fn(&Tx{tx: boltTx{tx: tx}, writable: true})
+	})
+	if err == nil {
+		s.changes.notify()
+	}
+	return err
+}
+
+func (s *Service) View(fn func(tx *Tx) error) error {
+	return s.DB.View(func(tx *bbolt.Tx) error {
+		return fn(&Tx{tx: boltTx{tx: tx}})
+	})
+}
+
+func (s *Service) Buckets() ([]string, error) {
+	var buckets []string
+	err := s.View(func(tx *Tx) error {
+		var err error
+		buckets, err = tx.Buckets()
+		return err
+	})
+	return buckets, err
+}
+
+func (s *Service) CreateBucket(bucketName string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.CreateBucket(bucketName)
 	})
-	return b, err
 }
 
 func (s *Service) IsExist(key, bucketName string) (bool, error) {
 	var exist bool
-	err := s.DB.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return fmt.Errorf("bucket `%s` not exist", bucketName)
-		}
-		exist = b.Get([]byte(key)) != nil
-		return nil
+	err := s.View(func(tx *Tx) error {
+		var err error
+		exist, err = tx.IsExist(key, bucketName)
+		return err
 	})
 	return exist, err
 }
 
 func (s *Service) DeleteBucket(bucketName string) error {
-	err := s.DB.Update(func(tx *bbolt.Tx) error {
-		err := tx.DeleteBucket([]byte(bucketName))
-		if err != nil {
-			return fmt.Errorf("delete bucket: `%s`", err)
-		}
-		return nil
+	return s.Update(func(tx *Tx) error {
+		return tx.DeleteBucket(bucketName)
 	})
-	return err
 }
 
 func (s *Service) Set(key, bucketName string, value []byte) error {
-	err := s.DB.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return fmt.Errorf("bucket `%s` not exist", bucketName)
-		}
-		return b.Put([]byte(key), value)
+	return s.Update(func(tx *Tx) error {
+		return tx.Set(key, bucketName, value)
 	})
-	return err
 }
 
 func (s *Service) GetOne(key, bucketName string) ([]byte, error) {
 	var v []byte
-	err := s.DB.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return fmt.Errorf("bucket `%s` not exist", bucketName)
-		}
-		v = b.Get([]byte(key))
-		if v == nil {
-			return fmt.Errorf("key `%s` not exist", key)
-		}
-		return nil
+	err := s.View(func(tx *Tx) error {
+		var err error
+		v, err = tx.GetOne(key, bucketName)
+		return err
 	})
 	return v, err
 }
 
 func (s *Service) GetAll(bucketName string) ([]KeyVal, error) {
 	var result []KeyVal
-	err := s.DB.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return fmt.Errorf("bucket `%s` not exist", bucketName)
-		}
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			result = append(result, KeyVal{
-				Key: string(k),
-				Val: v,
-			})
-		}
-		return nil
+	err := s.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetAll(bucketName)
+		return err
 	})
 	return result, err
 }
 
 func (s *Service) Delete(key, bucketName string) error {
-	err := s.DB.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return fmt.Errorf("bucket `%s` not exist", bucketName)
-		}
-		return b.Delete([]byte(key))
+	return s.Update(func(tx *Tx) error {
+		return tx.Delete(key, bucketName)
 	})
-	return err
 }
 
 func (s *Service) BatchDelete(keys []string, bucketName string) error {
-	err := s.DB.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return fmt.Errorf("bucket `%s` not exist", bucketName)
-		}
-		for _, key := range keys {
-			err := b.Delete([]byte(key))
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+	return s.Update(func(tx *Tx) error {
+		return tx.BatchDelete(keys, bucketName)
 	})
-	return err
 }
 
 func (s *Service) SetJson(key, bucketName string, value interface{}) error {
-	// Marshal and save the encoded data.
-	buf, err := json.Marshal(value)
-	if err != nil {
-		return err
-	}
-	return s.Set(key, bucketName, buf)
+	return s.Update(func(tx *Tx) error {
+		return tx.SetJson(key, bucketName, value)
+	})
 }
 
 func (s *Service) GetJson(key, bucketName string, ret interface{}) error {
-	data, err := s.GetOne(key, bucketName)
-	if err != nil {
+	return s.View(func(tx *Tx) error {
+		return tx.GetJson(key, bucketName, ret)
+	})
+}
+
+func (s *Service) GetJsonList(bucketName string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.GetJsonList(bucketName, ret)
+	})
+}
+
+func (s *Service) Version(key, bucketName string) (uint64, error) {
+	var version uint64
+	err := s.View(func(tx *Tx) error {
+		var err error
+		version, err = tx.Version(key, bucketName)
 		return err
-	}
-	if err := json.Unmarshal(data, &ret); err != nil {
+	})
+	return version, err
+}
+
+func (s *Service) SetIfVersion(key, bucketName string, value []byte, version uint64) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetIfVersion(key, bucketName, value, version)
+	})
+}
+
+func (s *Service) SetJsonIfVersion(key, bucketName string, value interface{}, version uint64) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetJsonIfVersion(key, bucketName, value, version)
+	})
+}
+
+func (s *Service) Scan(bucketName string, opts ScanOptions, fn func(item Item) error) (string, error) {
+	var next string
+	err := s.View(func(tx *Tx) error {
+		var err error
+		next, err = tx.Scan(bucketName, opts, fn)
 		return err
-	}
-	return nil
+	})
+	return next, err
 }
 
-func (s *Service) GetJsonList(bucketName string, ret interface{}) error {
+func (s *Service) GetPrefix(bucketName, prefix string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := s.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetPrefix(bucketName, prefix)
+		return err
+	})
+	return result, err
+}
 
-	v := reflect.ValueOf(ret)
-	if v.Kind() != reflect.Ptr {
-		return fmt.Errorf("non-pointer %v", v.Type())
-	}
-	// get the value that the pointer v points to.
-	v = v.Elem()
-	if v.Kind() != reflect.Slice {
-		return fmt.Errorf("can't fill non-slice value")
-	}
+func (s *Service) GetRange(bucketName, from, to string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := s.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetRange(bucketName, from, to)
+		return err
+	})
+	return result, err
+}
 
-	data, err := s.GetAll(bucketName)
-	if err != nil {
+func (s *Service) GetJsonPrefix(bucketName, prefix string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.GetJsonPrefix(bucketName, prefix, ret)
+	})
+}
+
+func (s *Service) GetJsonRange(bucketName, from, to string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.GetJsonRange(bucketName, from, to, ret)
+	})
+}
+
+func (s *Service) FindByIndex(bucketName, indexName, value string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := s.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.FindByIndex(bucketName, indexName, value)
 		return err
+	})
+	return result, err
+}
+
+func (s *Service) FindJsonByIndex(bucketName, indexName, value string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.FindJsonByIndex(bucketName, indexName, value, ret)
+	})
+}
+
+func (s *Service) RebuildIndex(bucketName, indexName string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.RebuildIndex(bucketName, indexName)
+	})
+}
+
+func (s *Service) SetWithTTL(key, bucketName string, value []byte, ttl time.Duration) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetWithTTL(key, bucketName, value, ttl)
+	})
+}
+
+func (s *Service) SetJsonWithTTL(key, bucketName string, value interface{}, ttl time.Duration) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetJsonWithTTL(key, bucketName, value, ttl)
+	})
+}
+
+func (s *Service) TTL(key, bucketName string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.View(func(tx *Tx) error {
+		var err error
+		ttl, err = tx.TTL(key, bucketName)
+		return err
+	})
+	return ttl, err
+}
+
+func (s *Service) Watch(ctx context.Context, bucketName string, after uint64) (<-chan Change, error) {
+	return watch(ctx, s, &s.changes, bucketName, after)
+}
+
+func (s *Service) BatchSet(data []KeyVal, bucketName string) (int, error) {
+	return writeChunks(s, data, int(batchChunk.Int64()), func(tx *Tx, chunk []KeyVal) error {
+		return tx.BatchSet(chunk, bucketName)
+	})
+}
+
+func (s *Service) BatchSetJson(data []JsonKeyVal, bucketName string) (int, error) {
+	return writeChunks(s, data, int(batchChunk.Int64()), func(tx *Tx, chunk []JsonKeyVal) error {
+		return tx.BatchSetJson(chunk, bucketName)
+	})
+}
+
+// boltTx implement transaction primitives over a bbolt transaction
+type boltTx struct {
+	tx *bbolt.Tx
+}
+
+func (b boltTx) buckets() ([]string, error) {
+	var buckets []string
+	err := b.tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+		buckets = append(buckets, string(name))
+		return nil
+	})
+	return buckets, err
+}
+
+func (b boltTx) createBucket(bucketName string) error {
+	_, err := b.tx.CreateBucketIfNotExists([]byte(bucketName))
+	return err
+}
+
+func (b boltTx) deleteBucket(bucketName string) error {
+	return b.tx.DeleteBucket([]byte(bucketName))
+}
+
+func (b boltTx) bucketExist(bucketName string) (bool, error) {
+	return b.tx.Bucket([]byte(bucketName)) != nil, nil
+}
+
+func (b boltTx) get(key, bucketName string) ([]byte, error) {
+	v := b.tx.Bucket([]byte(bucketName)).Get([]byte(key))
+	if v == nil {
+		return nil, nil
 	}
-	dataLen := len(data)
+	// value is only valid during the transaction
+	return copyBytes(v), nil
+}
 
-	v.Set(reflect.MakeSlice(v.Type(), dataLen, dataLen))
-	realVal := reflect.New(v.Type().Elem()).Interface()
+func (b boltTx) put(key, bucketName string, value []byte) error {
+	// bbolt need value to stay valid during the transaction
+	return b.tx.Bucket([]byte(bucketName)).Put([]byte(key), copyBytes(value))
+}
 
-	for i, kVal := range data {
-		if err := json.Unmarshal(kVal.Val, realVal); err != nil {
-			return err
+func (b boltTx) delete(key, bucketName string) error {
+	return b.tx.Bucket([]byte(bucketName)).Delete([]byte(key))
+}
+
+func (b boltTx) cursor(bucketName string) (Cursor, error) {
+	return b.tx.Bucket([]byte(bucketName)).Cursor(), nil
+}
+
+func init() {
+	Register(BoltBackend, func(path string) (Store, error) {
+		opts, err := ConfigOptions()
+		if err != nil {
+			return nil, err
 		}
-		v.Index(i).Set(reflect.ValueOf(realVal).Elem())
-	}
-	return nil
+		s, err := New(path, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
 }
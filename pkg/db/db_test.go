@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -12,14 +13,12 @@ import (
 
 func TestNew(t *testing.T) {
 
-	db := "/tmp/db_new"
+	db := filepath.Join(t.TempDir(), "db_new")
 	service, err := New(db)
 	if err != nil {
 		t.Errorf("create db failed %s", err.Error())
 		return
 	}
-	defer os.Remove(db)
-
 	_, err = os.Stat(db)
 	if os.IsNotExist(err) {
 		t.Errorf("db file not exist %s", err.Error())
@@ -45,14 +44,12 @@ func TestNew(t *testing.T) {
 
 func TestService_Close(t *testing.T) {
 
-	db := "/tmp/db_close"
+	db := filepath.Join(t.TempDir(), "db_close")
 	service, err := New(db)
 	if err != nil {
 		t.Errorf("create db failed %s", err.Error())
 		return
 	}
-	defer os.Remove(db)
-
 	err = service.Close()
 	if err != nil {
 		t.Errorf("close db failed %s", err.Error())
@@ -61,26 +58,19 @@ func TestService_Close(t *testing.T) {
 
 func TestService_CreateBucket(t *testing.T) {
 
-	db := "/tmp/db_create_bucket"
+	db := filepath.Join(t.TempDir(), "db_create_bucket")
 	service, err := New(db)
 	if err != nil {
 		t.Errorf("create db failed %s", err.Error())
 		return
 	}
-	defer os.Remove(db)
-
 	bucket := "test"
-	b, err := service.CreateBucket(bucket)
+	err = service.CreateBucket(bucket)
 	if err != nil {
 		t.Errorf("create bucket failed %s", err.Error())
 		return
 	}
 
-	if b == nil {
-		t.Error("invalid bucket")
-		return
-	}
-
 	_ = service.DB.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		if b == nil {
@@ -90,7 +80,7 @@ func TestService_CreateBucket(t *testing.T) {
 	})
 
 	bucket = ""
-	_, err = service.CreateBucket(bucket)
+	err = service.CreateBucket(bucket)
 	if err == nil {
 		t.Errorf("create bucket should fail %s", err.Error())
 		return
@@ -99,26 +89,19 @@ func TestService_CreateBucket(t *testing.T) {
 
 func TestService_DeleteBucket(t *testing.T) {
 
-	db := "/tmp/db_delete_bucket"
+	db := filepath.Join(t.TempDir(), "db_delete_bucket")
 	service, err := New(db)
 	if err != nil {
 		t.Errorf("create db failed %s", err.Error())
 		return
 	}
-	defer os.Remove(db)
-
 	bucket := "test_delete"
-	b, err := service.CreateBucket(bucket)
+	err = service.CreateBucket(bucket)
 	if err != nil {
 		t.Errorf("create bucket failed %s", err.Error())
 		return
 	}
 
-	if b == nil {
-		t.Error("invalid bucket")
-		return
-	}
-
 	err = service.DeleteBucket(bucket)
 	if err != nil {
 		t.Errorf("delete bucket failed %s", err.Error())
@@ -146,30 +129,22 @@ func createDBandBucket(db, bucket string) (*Service, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer os.Remove(db)
-
-	b, err := service.CreateBucket(bucket)
+	err = service.CreateBucket(bucket)
 	if err != nil {
-		return nil, err
-	}
-
-	if b == nil {
-		return nil, fmt.Errorf("create bucket %s failed", bucket)
+		return nil, fmt.Errorf("create bucket %s failed: %s", bucket, err)
 	}
 	return service, nil
 }
 
 func TestService_Set(t *testing.T) {
 
-	db := "/tmp/db_set"
+	db := filepath.Join(t.TempDir(), "db_set")
 	bucket := "test_set"
 	service, err := createDBandBucket(db, bucket)
 	if err != nil {
 		t.Error("error in prepare db and bucket")
 		return
 	}
-	defer os.Remove(db)
-
 	key := "test_key_set"
 	val := []byte("this is a test")
 
@@ -196,15 +171,13 @@ func TestService_Set(t *testing.T) {
 
 func TestService_GetOne(t *testing.T) {
 
-	db := "/tmp/db_get_one"
+	db := filepath.Join(t.TempDir(), "db_get_one")
 	bucket := "test_get_one"
 	service, err := createDBandBucket(db, bucket)
 	if err != nil {
 		t.Error("error in prepare db and bucket")
 		return
 	}
-	defer os.Remove(db)
-
 	key := "test_key_get_one"
 	val := []byte("this is a test")
 
@@ -233,15 +206,13 @@ func TestService_GetOne(t *testing.T) {
 }
 
 func TestService_GetAll(t *testing.T) {
-	db := "/tmp/db_get_all"
+	db := filepath.Join(t.TempDir(), "db_get_all")
 	bucket := "test_get_all"
 	service, err := createDBandBucket(db, bucket)
 	if err != nil {
 		t.Error("error in prepare db and bucket")
 		return
 	}
-	defer os.Remove(db)
-
 	kv := []KeyVal{{Key: "test1", Val: []byte("test1")}, {Key: "test2", Val: []byte("test2")}}
 
 	for _, kVal := range kv {
@@ -266,15 +237,13 @@ func TestService_GetAll(t *testing.T) {
 }
 
 func TestService_Delete(t *testing.T) {
-	db := "/tmp/db_get_delete"
+	db := filepath.Join(t.TempDir(), "db_get_delete")
 	bucket := "test_get_delete"
 	service, err := createDBandBucket(db, bucket)
 	if err != nil {
 		t.Error("error in prepare db and bucket")
 		return
 	}
-	defer os.Remove(db)
-
 	key := "test_key_delete"
 	val := []byte("this is a test")
 
@@ -309,15 +278,13 @@ func TestService_Delete(t *testing.T) {
 }
 
 func TestService_BatchDelete(t *testing.T) {
-	db := "/tmp/db_batch_delete"
+	db := filepath.Join(t.TempDir(), "db_batch_delete")
 	bucket := "test_batch_delete"
 	service, err := createDBandBucket(db, bucket)
 	if err != nil {
 		t.Error("error in prepare db and bucket")
 		return
 	}
-	defer os.Remove(db)
-
 	kv := []KeyVal{{Key: "test1", Val: []byte("test1")}, {Key: "test2", Val: []byte("test2")}}
 
 	for _, kVal := range kv {
@@ -354,15 +321,13 @@ func TestService_BatchDelete(t *testing.T) {
 }
 
 func TestService_SetJsons(t *testing.T) {
-	db := "/tmp/db_set_json"
+	db := filepath.Join(t.TempDir(), "db_set_json")
 	bucket := "test_set_json"
 	service, err := createDBandBucket(db, bucket)
 	if err != nil {
 		t.Error("error in prepare db and bucket")
 		return
 	}
-	defer os.Remove(db)
-
 	type User struct {
 		Username string
 		Email    string
@@ -386,15 +351,13 @@ func TestService_SetJsons(t *testing.T) {
 }
 
 func TestService_GetJson(t *testing.T) {
-	db := "/tmp/db_get_json"
+	db := filepath.Join(t.TempDir(), "db_get_json")
 	bucket := "test_get"
 	service, err := createDBandBucket(db, bucket)
 	if err != nil {
 		t.Error("error in prepare db and bucket")
 		return
 	}
-	defer os.Remove(db)
-
 	type User struct {
 		Username string
 		Email    string
@@ -431,15 +394,13 @@ func TestService_GetJson(t *testing.T) {
 }
 
 func TestService_GetJsonList(t *testing.T) {
-	db := "/tmp/db_get_json_list"
+	db := filepath.Join(t.TempDir(), "db_get_json_list")
 	bucket := "test_get_json_list"
 	service, err := createDBandBucket(db, bucket)
 	if err != nil {
 		t.Error("error in prepare db and bucket")
 		return
 	}
-	defer os.Remove(db)
-
 	type User struct {
 		Username string
 		Email    string
@@ -0,0 +1,417 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+)
+
+// encryptionTag is the first byte of encrypted values, an encrypted value is
+//
+//	tag | key id length | key id | wrapped data key | nonce | sealed value
+//
+// every value is sealed with its own random data key, which is sealed with
+// the master key named by key id. rotating the master key only re-wrap data keys
+const encryptionTag byte = 0x18
+
+// dataKeySize is the size of data keys, they are AES-256 keys
+const dataKeySize = 32
+
+// wrappedKeySize is the size of a data key sealed with a master key
+const wrappedKeySize = 12 + dataKeySize + 16
+
+// minIndexKeySize is the minimum size of the index key
+const minIndexKeySize = 16
+
+var (
+	encryptionKeys     = config.RegisterString("db.encryption.keys", "")
+	encryptionKeyID    = config.RegisterString("db.encryption.key_id", "")
+	encryptionIndexKey = config.RegisterString("db.encryption.index_key", "")
+	encryptionBuckets  = config.RegisterString("db.encryption.buckets", "")
+)
+
+// Keyring hold master keys by their id, old keys are kept after
+// a rotation so values encrypted with them are still readable
+type Keyring struct {
+	// Active is the id of the key new values are encrypted with
+	Active string
+	Keys   map[string][]byte
+	// IndexKey is the HMAC key index values of encrypted buckets are hashed
+	// with, it is not rotated with master keys. changing it need a rebuild
+	// of indexes of encrypted buckets
+	IndexKey []byte
+}
+
+// ParseKeyring parse keys in `id:base64-key,id:base64-key` format, keys should
+// be 16, 24 or 32 bytes for AES-128, AES-192 or AES-256. active should be one of ids
+func ParseKeyring(active, spec string) (*Keyring, error) {
+	k := &Keyring{Active: active, Keys: make(map[string][]byte)}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || len(parts[0]) > 255 {
+			return nil, fmt.Errorf("invalid key `%s`, expected id:base64-key", parts[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("key `%s` is not base64: %s", parts[0], err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("key `%s`: %s", parts[0], err)
+		}
+		k.Keys[parts[0]] = key
+	}
+	if _, ok := k.Keys[active]; !ok {
+		return nil, fmt.Errorf("active key `%s` not found", active)
+	}
+	return k, nil
+}
+
+var (
+	encryptionMu     sync.RWMutex
+	keyring          *Keyring
+	encryptedBuckets = make(map[string]bool)
+)
+
+// UseKeyring set the keys values are encrypted and decrypted with
+func UseKeyring(k *Keyring) {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+	keyring = k
+}
+
+// RegisterEncryption encrypt values written to bucket from now on, values
+// already stored are encrypted by Reencrypt. index values of bucket are
+// stored as their HMAC with the index key, keys of bucket are not encrypted
+func RegisterEncryption(bucketName string) {
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+	encryptedBuckets[bucketName] = true
+}
+
+// BucketEncrypted return true if values of bucket are encrypted
+func BucketEncrypted(bucketName string) bool {
+	encryptionMu.RLock()
+	defer encryptionMu.RUnlock()
+	return encryptedBuckets[bucketName]
+}
+
+// loadEncryptionConfig load keys and encrypted buckets from `db.encryption` config
+func loadEncryptionConfig() error {
+	return configureEncryption(encryptionBuckets.String(), encryptionKeys.String(),
+		encryptionKeyID.String(), encryptionIndexKey.String())
+}
+
+// configureEncryption register encrypted buckets and use their keys, it fail
+// when writes to the buckets would fail for a missing key
+func configureEncryption(buckets, keys, keyID, indexKey string) error {
+	var names []string
+	for _, name := range strings.Split(buckets, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if keys == "" {
+		if len(names) > 0 {
+			return fmt.Errorf("db.encryption.keys is required to encrypt buckets %s", strings.Join(names, ", "))
+		}
+		return nil
+	}
+	k, err := ParseKeyring(keyID, keys)
+	if err != nil {
+		return fmt.Errorf("invalid db.encryption.keys: %s", err)
+	}
+	if indexKey != "" {
+		if k.IndexKey, err = base64.StdEncoding.DecodeString(indexKey); err != nil {
+			return fmt.Errorf("invalid db.encryption.index_key: %s", err)
+		}
+		if len(k.IndexKey) < minIndexKeySize {
+			return fmt.Errorf("invalid db.encryption.index_key: it should be at least %d bytes", minIndexKeySize)
+		}
+	}
+	for _, name := range names {
+		if len(k.IndexKey) == 0 && len(bucketIndexes(name)) > 0 {
+			return fmt.Errorf("db.encryption.index_key is required to encrypt bucket %s which has indexes", name)
+		}
+	}
+	for _, name := range names {
+		RegisterEncryption(name)
+	}
+	UseKeyring(k)
+	return nil
+}
+
+// lookupKey return master key of id, the active one if id is empty
+func lookupKey(id string) (string, []byte, error) {
+	encryptionMu.RLock()
+	k := keyring
+	encryptionMu.RUnlock()
+
+	if k == nil {
+		return "", nil, fmt.Errorf("no encryption key is configured")
+	}
+	if id == "" {
+		id = k.Active
+	}
+	key, ok := k.Keys[id]
+	if !ok {
+		return "", nil, fmt.Errorf("encryption key `%s` not found", id)
+	}
+	return id, key, nil
+}
+
+// hashIndexValue return the HMAC of an index value of an encrypted bucket, it
+// is bound to the bucket and index so same values of them have different hashes
+func hashIndexValue(bucketName, indexName, value string) (string, error) {
+	encryptionMu.RLock()
+	k := keyring
+	encryptionMu.RUnlock()
+
+	if k == nil || len(k.IndexKey) == 0 {
+		return "", fmt.Errorf("no index key is configured for encrypted bucket `%s`", bucketName)
+	}
+	mac := hmac.New(sha256.New, k.IndexKey)
+	mac.Write([]byte(bucketName + "\x00" + indexName + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// valueAAD bind sealed values to their bucket and key,
+// so they can not be swapped with each other
+func valueAAD(bucketName, key string) []byte {
+	return []byte(bucketName + "\x00" + key)
+}
+
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func unseal(key, sealed, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed data is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
+
+// envelope is the parsed form of an encrypted value
+type envelope struct {
+	keyID   string
+	wrapped []byte
+	sealed  []byte
+}
+
+func isEncryptedValue(value []byte) bool {
+	return len(value) > 0 && value[0] == encryptionTag
+}
+
+func parseEnvelope(value []byte) (envelope, error) {
+	var e envelope
+	if len(value) < 2 || len(value) < 2+int(value[1])+wrappedKeySize {
+		return e, fmt.Errorf("encrypted value is too short")
+	}
+	idEnd := 2 + int(value[1])
+	e.keyID = string(value[2:idEnd])
+	e.wrapped = value[idEnd : idEnd+wrappedKeySize]
+	e.sealed = value[idEnd+wrappedKeySize:]
+	return e, nil
+}
+
+func (e envelope) bytes() []byte {
+	buf := make([]byte, 0, 2+len(e.keyID)+len(e.wrapped)+len(e.sealed))
+	buf = append(buf, encryptionTag, byte(len(e.keyID)))
+	buf = append(buf, e.keyID...)
+	buf = append(buf, e.wrapped...)
+	return append(buf, e.sealed...)
+}
+
+// dataKey unwrap data key of envelope with its master key
+func (e envelope) dataKey() ([]byte, error) {
+	_, master, err := lookupKey(e.keyID)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := unseal(master, e.wrapped, []byte(e.keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key failed: %s", err)
+	}
+	return dek, nil
+}
+
+// wrap seal data key with the active master key
+func wrap(dek []byte) (string, []byte, error) {
+	id, master, err := lookupKey("")
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err := seal(master, dek, []byte(id))
+	return id, wrapped, err
+}
+
+// encrypt seal value of key if bucket is encrypted
+func encrypt(bucketName, key string, value []byte) ([]byte, error) {
+	if !BucketEncrypted(bucketName) {
+		return value, nil
+	}
+	dek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+	id, wrapped, err := wrap(dek)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt bucket `%s` failed: %s", bucketName, err)
+	}
+	sealed, err := seal(dek, value, valueAAD(bucketName, key))
+	if err != nil {
+		return nil, err
+	}
+	return envelope{keyID: id, wrapped: wrapped, sealed: sealed}.bytes(), nil
+}
+
+// decrypt return the plain value, value is returned as it is if it is not encrypted
+func decrypt(bucketName, key string, value []byte) ([]byte, error) {
+	if !isEncryptedValue(value) {
+		return value, nil
+	}
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := e.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := unseal(dek, e.sealed, valueAAD(bucketName, key))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt failed: %s", err)
+	}
+	return plain, nil
+}
+
+// Reencrypt rewrite values of bucket to match its encryption settings, values
+// are encrypted with the active key if bucket is encrypted and decrypted if
+// it is not. data keys of values encrypted with an old key are re-wrapped
+// with the active key, the values themselves are not decrypted. indexes of
+// bucket are rebuilt so their values are hashed only if bucket is encrypted
+func (tx *Tx) Reencrypt(bucketName string) (int, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := tx.checkBucket(bucketName); err != nil {
+		return 0, err
+	}
+	framed, err := tx.framed(bucketName)
+	if err != nil {
+		return 0, err
+	}
+	if !framed {
+		// values of a bucket which is not framed are not encrypted
+		if !BucketEncrypted(bucketName) {
+			return 0, nil
+		}
+		if err := tx.frame(bucketName); err != nil {
+			return 0, err
+		}
+	}
+
+	// collect first, a bucket should not be changed while iterating it
+	c, err := tx.tx.cursor(bucketName)
+	if err != nil {
+		return 0, err
+	}
+	var data []KeyVal
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		data = append(data, KeyVal{Key: string(k), Val: copyBytes(v)})
+	}
+	if err := cursorErr(c); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, kVal := range data {
+		buf, err := reencryptValue(bucketName, kVal.Key, kVal.Val)
+		if err != nil {
+			return count, fmt.Errorf("key `%s`: %s", kVal.Key, err)
+		}
+		if buf == nil {
+			continue
+		}
+		if err := tx.tx.put(kVal.Key, bucketName, buf); err != nil {
+			return count, err
+		}
+		count++
+	}
+	// index entries are kept in clear or hashed by the bucket encryption
+	for _, idx := range bucketIndexes(bucketName) {
+		if err := tx.buildIndex(bucketName, idx); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// reencryptValue return the new stored value, nil if value does not need a change
+func reencryptValue(bucketName, key string, value []byte) ([]byte, error) {
+	encrypted := BucketEncrypted(bucketName)
+	if !isEncryptedValue(value) {
+		if !encrypted {
+			return nil, nil
+		}
+		return encrypt(bucketName, key, value)
+	}
+	if !encrypted {
+		return decrypt(bucketName, key, value)
+	}
+
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return nil, err
+	}
+	active, _, err := lookupKey("")
+	if err != nil {
+		return nil, err
+	}
+	if e.keyID == active {
+		return nil, nil
+	}
+	dek, err := e.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	e.keyID, e.wrapped, err = wrap(dek)
+	if err != nil {
+		return nil, err
+	}
+	return e.bytes(), nil
+}
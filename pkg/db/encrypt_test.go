@@ -0,0 +1,275 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+	var spec []string
+	for _, id := range ids {
+		// same id always get the same key
+		key := sha256.Sum256([]byte(id))
+		spec = append(spec, id+":"+base64.StdEncoding.EncodeToString(key[:]))
+	}
+	k, err := ParseKeyring(active, strings.Join(spec, ","))
+	if err != nil {
+		t.Fatalf("parse keyring failed %s", err)
+	}
+	indexKey := sha256.Sum256([]byte("index"))
+	k.IndexKey = indexKey[:]
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	k := testKeyring(t, "k2", "k1", "k2")
+	if k.Active != "k2" || len(k.Keys) != 2 {
+		t.Errorf("unexpected keyring %v", k)
+	}
+
+	tests := []struct {
+		active, spec string
+	}{
+		{"k1", "k1"},
+		{"k1", "k1:not-base64!"},
+		{"k1", "k1:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"k2", "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32))},
+	}
+	for _, tt := range tests {
+		if _, err := ParseKeyring(tt.active, tt.spec); err == nil {
+			t.Errorf("parse %s with active %s should fail", tt.spec, tt.active)
+		}
+	}
+}
+
+func TestConfigureEncryption(t *testing.T) {
+	defer UseKeyring(nil)
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	RegisterIndex("configure-indexed", JsonIndex("team", indexedUser{}, func(r interface{}) []string {
+		return []string{r.(*indexedUser).Team}
+	}))
+
+	tests := []struct {
+		buckets, keys, indexKey string
+		ok                      bool
+	}{
+		{"", "", "", true},
+		{"configure", "k1:" + key, "", true},
+		{"configure", "", "", false},
+		{"configure-indexed", "k1:" + key, "", false},
+		{"configure-indexed", "k1:" + key, base64.StdEncoding.EncodeToString([]byte("short")), false},
+		{"configure-indexed", "k1:" + key, key, true},
+	}
+	for _, tt := range tests {
+		err := configureEncryption(tt.buckets, tt.keys, "k1", tt.indexKey)
+		if (err == nil) != tt.ok {
+			t.Errorf("configure buckets %q keys %q index key %q: unexpected error %v", tt.buckets, tt.keys, tt.indexKey, err)
+		}
+	}
+	if !BucketEncrypted("configure-indexed") {
+		t.Error("bucket should be encrypted after a valid config")
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	defer UseKeyring(nil)
+	UseKeyring(testKeyring(t, "k1", "k1"))
+
+	bucket := "encrypted"
+	RegisterEncryption(bucket)
+	RegisterCompression(bucket, Snappy)
+
+	s := NewMemory()
+	mustCreateBucket(t, s, bucket)
+	value := largeValue(1024)
+	mustSet(t, s, bucket, "a", string(value))
+
+	_ = s.View(func(tx *Tx) error {
+		raw, _ := tx.tx.get("a", bucket)
+		if !isEncryptedValue(raw) || bytes.Contains(raw, []byte("compress me")) {
+			t.Error("value should be stored encrypted")
+		}
+		// values can not be moved to another key
+		if _, err := decrypt(bucket, "b", raw); err == nil {
+			t.Error("decrypt with another key should fail")
+		}
+		return nil
+	})
+
+	v, err := s.GetOne("a", bucket)
+	if err != nil || !bytes.Equal(v, value) {
+		t.Errorf("unexpected decrypted value, %v", err)
+	}
+
+	UseKeyring(nil)
+	if _, err := s.GetOne("a", bucket); err == nil {
+		t.Error("read should fail without keys")
+	}
+	if err := s.Set("b", bucket, value); err == nil {
+		t.Error("write should fail without keys")
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	defer UseKeyring(nil)
+	UseKeyring(testKeyring(t, "k1", "k1"))
+
+	bucket := "reencrypt"
+	s := NewMemory()
+	mustCreateBucket(t, s, bucket)
+	mustSet(t, s, bucket, "plain", "plain value")
+
+	reencrypt := func(expected int) {
+		t.Helper()
+		err := s.Update(func(tx *Tx) error {
+			n, err := tx.Reencrypt(bucket)
+			if n != expected {
+				t.Errorf("expected %d records rewritten but got %d", expected, n)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("reencrypt failed %s", err)
+		}
+	}
+	keyID := func(key string) string {
+		var id string
+		_ = s.View(func(tx *Tx) error {
+			raw, _ := tx.tx.get(key, bucket)
+			if isEncryptedValue(raw) {
+				e, _ := parseEnvelope(raw)
+				id = e.keyID
+			}
+			return nil
+		})
+		return id
+	}
+
+	// existing values are encrypted once the bucket opt in
+	RegisterEncryption(bucket)
+	mustSet(t, s, bucket, "encrypted", "encrypted value")
+	reencrypt(1)
+	if keyID("plain") != "k1" {
+		t.Error("plain value should be encrypted with k1")
+	}
+
+	// rotation re-wrap values with the new key, old one is still needed to read
+	UseKeyring(testKeyring(t, "k2", "k1", "k2"))
+	mustSet(t, s, bucket, "new", "new value")
+	reencrypt(2)
+	UseKeyring(testKeyring(t, "k2", "k2"))
+	for key, val := range map[string]string{"plain": "plain value", "encrypted": "encrypted value", "new": "new value"} {
+		if keyID(key) != "k2" {
+			t.Errorf("%s should be encrypted with k2", key)
+		}
+		if v, err := s.GetOne(key, bucket); err != nil || string(v) != val {
+			t.Errorf("unexpected value of %s %q, %v", key, v, err)
+		}
+	}
+	reencrypt(0)
+}
+
+func TestEncryptionReservedBytes(t *testing.T) {
+	defer UseKeyring(nil)
+	UseKeyring(testKeyring(t, "k1", "k1"))
+
+	s := NewMemory()
+	mustCreateBucket(t, s, "plain")
+	mustCreateBucket(t, s, "reserved")
+	value := []byte{encryptionTag, 0, 1}
+
+	// a value starting with the encryption tag is not taken for an encrypted one
+	mustSet(t, s, "plain", "a", string(value))
+	if v, err := s.GetOne("a", "plain"); err != nil || !bytes.Equal(v, value) {
+		t.Errorf("unexpected value %q, %v", v, err)
+	}
+
+	mustSet(t, s, "reserved", "old", string(value))
+	RegisterEncryption("reserved")
+	defer func() {
+		encryptionMu.Lock()
+		delete(encryptedBuckets, "reserved")
+		encryptionMu.Unlock()
+	}()
+	mustSet(t, s, "reserved", "new", string(value))
+	err := s.Update(func(tx *Tx) error {
+		_, err := tx.Reencrypt("reserved")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("reencrypt failed %s", err)
+	}
+	for _, key := range []string{"old", "new"} {
+		if v, err := s.GetOne(key, "reserved"); err != nil || !bytes.Equal(v, value) {
+			t.Errorf("unexpected value of %s %q, %v", key, v, err)
+		}
+	}
+}
+
+func TestEncryptionIndex(t *testing.T) {
+	defer UseKeyring(nil)
+	UseKeyring(testKeyring(t, "k1", "k1"))
+
+	bucket := "encrypted-index"
+	RegisterIndex(bucket, JsonIndex("team", indexedUser{}, func(r interface{}) []string {
+		return []string{r.(*indexedUser).Team}
+	}))
+	RegisterUnique(bucket, JsonIndex("name", indexedUser{}, func(r interface{}) []string {
+		return []string{r.(*indexedUser).Name}
+	}))
+
+	s := NewMemory()
+	mustCreateBucket(t, s, bucket)
+	if err := s.SetJson("u1", bucket, indexedUser{Name: "alice", Team: "red"}); err != nil {
+		t.Fatalf("set json failed %s", err)
+	}
+
+	// plain index entries are hashed once the bucket is encrypted
+	RegisterEncryption(bucket)
+	err := s.Update(func(tx *Tx) error {
+		_, err := tx.Reencrypt(bucket)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("reencrypt failed %s", err)
+	}
+	if err := s.SetJson("u2", bucket, indexedUser{Name: "bob", Team: "red"}); err != nil {
+		t.Fatalf("set json failed %s", err)
+	}
+
+	_ = s.View(func(tx *Tx) error {
+		for _, name := range []string{"team", "name"} {
+			c, _ := tx.tx.cursor(indexBucket(bucket, name))
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				for _, plain := range []string{"red", "alice", "bob"} {
+					if strings.HasPrefix(string(k), plain) {
+						t.Errorf("index %s has value in clear %q", name, k)
+					}
+				}
+			}
+		}
+		return nil
+	})
+
+	found, err := s.FindByIndex(bucket, "team", "red")
+	if err != nil || len(found) != 2 {
+		t.Errorf("expected 2 records in team red but got %d, %v", len(found), err)
+	}
+	err = s.SetJson("u3", bucket, indexedUser{Name: "alice"})
+	var unique *UniqueError
+	if !errors.As(err, &unique) || unique.Value != "alice" || unique.Key != "u1" {
+		t.Errorf("unique index should work on hashed values, got %v", err)
+	}
+
+	k := testKeyring(t, "k1", "k1")
+	k.IndexKey = nil
+	UseKeyring(k)
+	if _, err := s.FindByIndex(bucket, "team", "red"); err == nil {
+		t.Error("find should fail without index key")
+	}
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ExportRecord is a line of a JSON Lines export, Value hold JSON values
+// as they are and Raw hold any other value base64 encoded
+type ExportRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Raw   []byte          `json:"raw,omitempty"`
+}
+
+// ImportMode decide what import do with keys which already exist
+type ImportMode string
+
+// import modes
+const (
+	// ImportUpsert overwrite existing keys
+	ImportUpsert ImportMode = "upsert"
+	// ImportSkip keep existing keys and skip the imported record
+	ImportSkip ImportMode = "skip"
+	// ImportFail stop the import with an error
+	ImportFail ImportMode = "fail"
+)
+
+// ImportResult report what an import did
+type ImportResult struct {
+	Written int
+	Skipped int
+}
+
+// Export write records of bucket to w as JSON Lines, one record per line
+// in key order. records are streamed so bucket does not need to fit in memory.
+// import of the export store the same bytes
+func Export(s Store, bucketName string, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	count := 0
+	_, err := s.Scan(bucketName, ScanOptions{}, func(item Item) error {
+		rec := ExportRecord{Key: item.Key}
+		if compactJSON(item.Value) {
+			rec.Value = json.RawMessage(item.Value)
+		} else {
+			rec.Raw = item.Value
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// compactJSON return true if value is JSON which the encoder write as it is,
+// other values like indented JSON are exported raw so they are not changed
+func compactJSON(value []byte) bool {
+	if !json.Valid(value) {
+		return false
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, value); err != nil {
+		return false
+	}
+	return bytes.Equal(buf.Bytes(), value)
+}
+
+// Import read JSON Lines records written by Export from r into bucket, the
+// bucket is created if not exist. records are written in transactions of
+// `db.batch.chunk` records, so when it fails the chunks before the failing
+// one are kept
+func Import(s Store, bucketName string, r io.Reader, mode ImportMode) (ImportResult, error) {
+	return importChunks(s, bucketName, r, mode, int(batchChunk.Int64()))
+}
+
+// importChunks import records in transactions of size records, only a
+// chunk is held in memory. zero size import all records at once
+func importChunks(s Store, bucketName string, r io.Reader, mode ImportMode, size int) (ImportResult, error) {
+	var result ImportResult
+	switch mode {
+	case ImportUpsert, ImportSkip, ImportFail:
+	default:
+		return result, fmt.Errorf("unknown import mode `%s`", mode)
+	}
+	if err := s.CreateBucket(bucketName); err != nil {
+		return result, err
+	}
+
+	reader := bufio.NewReader(r)
+	line := 0
+	var batch []ExportRecord
+	for {
+		buf, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return result, readErr
+		}
+		if buf = bytes.TrimSpace(buf); len(buf) > 0 {
+			line++
+			var rec ExportRecord
+			if err := json.Unmarshal(buf, &rec); err != nil {
+				return result, fmt.Errorf("line %d: %s", line, err)
+			}
+			if rec.Key == "" {
+				return result, fmt.Errorf("line %d: key required", line)
+			}
+			batch = append(batch, rec)
+		}
+
+		if (size > 0 && len(batch) >= size) || (readErr == io.EOF && len(batch) > 0) {
+			var skipped int
+			_, err := writeChunks(s, batch, size, func(tx *Tx, chunk []ExportRecord) error {
+				data, err := importData(tx, bucketName, chunk, mode)
+				if err != nil {
+					return err
+				}
+				skipped = len(chunk) - len(data)
+				return tx.BatchSet(data, bucketName)
+			})
+			if err != nil {
+				return result, err
+			}
+			result.Written += len(batch) - skipped
+			result.Skipped += skipped
+			batch = batch[:0]
+		}
+		if readErr == io.EOF {
+			return result, nil
+		}
+	}
+}
+
+// importData return records of chunk which should be written in mode
+func importData(tx *Tx, bucketName string, chunk []ExportRecord, mode ImportMode) ([]KeyVal, error) {
+	data := make([]KeyVal, 0, len(chunk))
+	for _, rec := range chunk {
+		if mode != ImportUpsert {
+			exist, err := tx.IsExist(rec.Key, bucketName)
+			if err != nil {
+				return nil, err
+			}
+			if exist && mode == ImportFail {
+				return nil, fmt.Errorf("key `%s` already exist in bucket `%s`", rec.Key, bucketName)
+			}
+			if exist {
+				continue
+			}
+		}
+		value := []byte(rec.Value)
+		if rec.Value == nil {
+			// keep empty values non nil, nil mean key not exist
+			value = append([]byte{}, rec.Raw...)
+		}
+		data = append(data, KeyVal{Key: rec.Key, Val: value})
+	}
+	return data, nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	src := NewMemory()
+	mustCreateBucket(t, src, "test")
+	mustSet(t, src, "test", "a", `{"name":"a"}`)
+	mustSet(t, src, "test", "b", "not json")
+	mustSet(t, src, "test", "c", "")
+
+	var buf bytes.Buffer
+	count, err := Export(src, "test", &buf)
+	if err != nil || count != 3 {
+		t.Fatalf("export failed %d, %v", count, err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 3 {
+		t.Errorf("expected 3 lines but got %d: %s", lines, buf.String())
+	}
+	if !strings.HasPrefix(buf.String(), `{"key":"a","value":{"name":"a"}}`) {
+		t.Errorf("json values should be exported as they are: %s", buf.String())
+	}
+
+	dst := NewMemory()
+	result, err := importChunks(dst, "test", bytes.NewReader(buf.Bytes()), ImportUpsert, 2)
+	if err != nil || result.Written != 3 {
+		t.Fatalf("import failed %+v, %v", result, err)
+	}
+	srcAll, _ := src.GetAll("test")
+	dstAll, _ := dst.GetAll("test")
+	if len(dstAll) != 3 {
+		t.Fatalf("unexpected imported records %v", dstAll)
+	}
+	for i := range srcAll {
+		if srcAll[i].Key != dstAll[i].Key || !bytes.Equal(srcAll[i].Val, dstAll[i].Val) {
+			t.Errorf("imported record %v is not same as exported %v", dstAll[i], srcAll[i])
+		}
+	}
+	if exist, _ := dst.IsExist("c", "test"); !exist {
+		t.Error("empty value should be imported")
+	}
+}
+
+func TestExportImport_Faithful(t *testing.T) {
+	src := NewMemory()
+	mustCreateBucket(t, src, "test")
+	values := map[string]string{
+		"compact":  `{"t":"a<b&c>d"}`,
+		"spaced":   `{ "t": "a<b" }`,
+		"indented": "{\n  \"t\": 1\n}",
+		"number":   ` 1`,
+	}
+	for key, value := range values {
+		mustSet(t, src, "test", key, value)
+	}
+
+	var buf bytes.Buffer
+	if _, err := Export(src, "test", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"value":{"t":"a<b&c>d"}`) {
+		t.Errorf("compact json should be exported as it is: %s", buf.String())
+	}
+
+	dst := NewMemory()
+	if _, err := Import(dst, "test", &buf, ImportUpsert); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		if v, err := dst.GetOne(key, "test"); err != nil || string(v) != value {
+			t.Errorf("value of %s changed by export and import: %q, %v", key, v, err)
+		}
+	}
+}
+
+func TestImportModes(t *testing.T) {
+	input := `{"key":"a","value":1}` + "\n" + `{"key":"b","value":2}` + "\n"
+
+	newStore := func() Store {
+		s := NewMemory()
+		mustCreateBucket(t, s, "test")
+		mustSet(t, s, "test", "a", "0")
+		return s
+	}
+
+	s := newStore()
+	result, err := Import(s, "test", strings.NewReader(input), ImportUpsert)
+	if err != nil || result.Written != 2 || result.Skipped != 0 {
+		t.Errorf("unexpected upsert result %+v, %v", result, err)
+	}
+	if v, _ := s.GetOne("a", "test"); string(v) != "1" {
+		t.Errorf("upsert should overwrite existing key, got %s", v)
+	}
+
+	s = newStore()
+	result, err = Import(s, "test", strings.NewReader(input), ImportSkip)
+	if err != nil || result.Written != 1 || result.Skipped != 1 {
+		t.Errorf("unexpected skip result %+v, %v", result, err)
+	}
+	if v, _ := s.GetOne("a", "test"); string(v) != "0" {
+		t.Errorf("skip should keep existing key, got %s", v)
+	}
+
+	s = newStore()
+	if _, err = Import(s, "test", strings.NewReader(input), ImportFail); err == nil {
+		t.Error("fail mode should fail on existing key")
+	}
+	if exist, _ := s.IsExist("b", "test"); exist {
+		t.Error("failed chunk should be rolled back")
+	}
+
+	// chunks before the failing one are kept
+	s = newStore()
+	reversed := `{"key":"b","value":2}` + "\n" + `{"key":"a","value":1}` + "\n"
+	if result, err = importChunks(s, "test", strings.NewReader(reversed), ImportFail, 1); err == nil || result.Written != 1 {
+		t.Errorf("second chunk should fail after the first is written, got %+v, %v", result, err)
+	}
+	if exist, _ := s.IsExist("b", "test"); !exist {
+		t.Error("committed chunk should be kept")
+	}
+
+	if _, err = Import(s, "test", strings.NewReader(input), "merge"); err == nil {
+		t.Error("unknown mode should fail")
+	}
+	if _, err = Import(s, "test", strings.NewReader("{bad json}\n"), ImportUpsert); err == nil {
+		t.Error("invalid line should fail")
+	}
+}
@@ -0,0 +1,371 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Index is a secondary index on a bucket, index entries are kept in a companion
+// bucket and updated in the same transaction as the records
+type Index struct {
+	Name string
+	// Extract return index values of a record, empty values are not indexed
+	Extract func(key string, value []byte) ([]string, error)
+	// Unique reject writes which add a value already indexed for another key
+	Unique bool
+}
+
+// ErrUniqueViolation is matched by errors.Is for all *UniqueError errors
+var ErrUniqueViolation = errors.New("unique constraint violation")
+
+// UniqueError is returned when a write violate a unique index
+type UniqueError struct {
+	Bucket string
+	// Field is the name of the unique index
+	Field string
+	Value string
+	// Key is the record already holding the value
+	Key string
+}
+
+func (e *UniqueError) Error() string {
+	return fmt.Sprintf("%s: `%s` of bucket `%s` already has value `%s` in key `%s`",
+		ErrUniqueViolation, e.Field, e.Bucket, e.Value, e.Key)
+}
+
+// Is make errors.Is(err, ErrUniqueViolation) true
+func (e *UniqueError) Is(target error) bool {
+	return target == ErrUniqueViolation
+}
+
+// indexSeparator separate index value from record key in index entries
+const indexSeparator = "\x00"
+
+var (
+	indexesMu sync.RWMutex
+	indexes   = make(map[string][]Index)
+)
+
+// RegisterIndex declare an index on bucket, indexes should be registered
+// before the bucket is created so missing indexes are built by CreateBucket
+func RegisterIndex(bucketName string, index Index) {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	if index.Name == "" || index.Extract == nil {
+		panic("db: register index with empty name or extractor")
+	}
+	for _, idx := range indexes[bucketName] {
+		if idx.Name == index.Name {
+			panic("db: register called twice for index " + index.Name + " of bucket " + bucketName)
+		}
+	}
+	indexes[bucketName] = append(indexes[bucketName], index)
+}
+
+// RegisterUnique declare a unique index on bucket, see RegisterIndex
+func RegisterUnique(bucketName string, index Index) {
+	index.Unique = true
+	RegisterIndex(bucketName, index)
+}
+
+// JsonIndex return an index which pass records decoded with their codec into
+// a new value of model type to extract, e.g.
+//
+//	JsonIndex("sprint", Task{}, func(r interface{}) []string { return []string{r.(*Task).Sprint} })
+func JsonIndex(name string, model interface{}, extract func(record interface{}) []string) Index {
+	t := reflect.TypeOf(model)
+	return Index{
+		Name: name,
+		Extract: func(key string, value []byte) ([]string, error) {
+			record := reflect.New(t).Interface()
+			if err := decodeValue(value, record); err != nil {
+				return nil, err
+			}
+			return extract(record), nil
+		},
+	}
+}
+
+// Indexes return name of indexes registered on bucket
+func Indexes(bucketName string) []string {
+	var names []string
+	for _, idx := range bucketIndexes(bucketName) {
+		names = append(names, idx.Name)
+	}
+	return names
+}
+
+func bucketIndexes(bucketName string) []Index {
+	indexesMu.RLock()
+	defer indexesMu.RUnlock()
+	return indexes[bucketName]
+}
+
+func findIndex(bucketName, indexName string) (Index, error) {
+	for _, idx := range bucketIndexes(bucketName) {
+		if idx.Name == indexName {
+			return idx, nil
+		}
+	}
+	return Index{}, fmt.Errorf("index `%s` not exist on bucket `%s`", indexName, bucketName)
+}
+
+// indexBucket is the companion bucket holding entries of an index
+func indexBucket(bucketName, indexName string) string {
+	return internalPrefix + "index." + bucketName + "." + indexName
+}
+
+// checkIndexValue reject values holding the separator, they would be
+// mixed up with entries of the value before it
+func checkIndexValue(indexName, value string) error {
+	if strings.Contains(value, indexSeparator) {
+		return fmt.Errorf("value %q of index `%s` contain a NUL byte", value, indexName)
+	}
+	return nil
+}
+
+// indexToken return how value is kept in index entries, values of encrypted
+// buckets are kept as their HMAC so they are not stored in clear
+func indexToken(bucketName, indexName, value string) (string, error) {
+	if !BucketEncrypted(bucketName) {
+		return value, nil
+	}
+	return hashIndexValue(bucketName, indexName, value)
+}
+
+func indexEntry(value, key string) string {
+	return value + indexSeparator + key
+}
+
+// indexValues return the unique non-empty index values of a record
+func indexValues(idx Index, key string, value []byte) (map[string]bool, error) {
+	values := make(map[string]bool)
+	if value == nil {
+		return values, nil
+	}
+	list, err := idx.Extract(key, value)
+	if err != nil {
+		return nil, fmt.Errorf("extract index `%s` of key `%s`: %s", idx.Name, key, err)
+	}
+	for _, v := range list {
+		if v == "" {
+			continue
+		}
+		if err := checkIndexValue(idx.Name, v); err != nil {
+			return nil, fmt.Errorf("key `%s`: %s", key, err)
+		}
+		values[v] = true
+	}
+	return values, nil
+}
+
+// updateIndexes replace index entries of old record value with the new one,
+// a nil value mean the record does not exist
+func (tx *Tx) updateIndexes(key, bucketName string, old, value []byte) error {
+	for _, idx := range bucketIndexes(bucketName) {
+		oldValues, err := indexValues(idx, key, old)
+		if err != nil {
+			return err
+		}
+		newValues, err := indexValues(idx, key, value)
+		if err != nil {
+			return err
+		}
+
+		ib := indexBucket(bucketName, idx.Name)
+		if err := tx.tx.createBucket(ib); err != nil {
+			return err
+		}
+		for v := range oldValues {
+			if !newValues[v] {
+				token, err := indexToken(bucketName, idx.Name, v)
+				if err != nil {
+					return err
+				}
+				if err := tx.tx.delete(indexEntry(token, key), ib); err != nil {
+					return err
+				}
+			}
+		}
+		for v := range newValues {
+			if !oldValues[v] {
+				if err := tx.addIndexEntry(bucketName, idx, v, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addIndexEntry add key to the index value, unique indexes are checked
+// to not have the value for another key. an expired record which is not
+// swept yet does not own its value, it is deleted to free the value
+func (tx *Tx) addIndexEntry(bucketName string, idx Index, value, key string) error {
+	ib := indexBucket(bucketName, idx.Name)
+	token, err := indexToken(bucketName, idx.Name, value)
+	if err != nil {
+		return err
+	}
+	if idx.Unique {
+		c, err := tx.tx.cursor(ib)
+		if err != nil {
+			return err
+		}
+		// collect first, a bucket should not be changed while iterating it
+		var holders []string
+		prefix := token + indexSeparator
+		for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+			if existing := string(k)[len(prefix):]; existing != key {
+				holders = append(holders, existing)
+			}
+		}
+		if err := cursorErr(c); err != nil {
+			return err
+		}
+		for _, existing := range holders {
+			expired, err := tx.expired(existing, bucketName)
+			if err != nil {
+				return err
+			}
+			if !expired {
+				return &UniqueError{Bucket: bucketName, Field: idx.Name, Value: value, Key: existing}
+			}
+		}
+		for _, existing := range holders {
+			if err := tx.delete(existing, bucketName); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.tx.put(indexEntry(token, key), ib, []byte{})
+}
+
+// FindByIndex return records of bucket which have value in the index, ordered by key
+func (tx *Tx) FindByIndex(bucketName, indexName, value string) ([]KeyVal, error) {
+	if err := tx.checkBucket(bucketName); err != nil {
+		return nil, err
+	}
+	if _, err := findIndex(bucketName, indexName); err != nil {
+		return nil, err
+	}
+	if err := checkIndexValue(indexName, value); err != nil {
+		return nil, err
+	}
+
+	ib := indexBucket(bucketName, indexName)
+	ok, err := tx.tx.bucketExist(ib)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	token, err := indexToken(bucketName, indexName, value)
+	if err != nil {
+		return nil, err
+	}
+	prefix := token + indexSeparator
+	entries, err := tx.getScan(ib, ScanOptions{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []KeyVal
+	for _, entry := range entries {
+		key := entry.Key[len(prefix):]
+		v, err := tx.getValue(key, bucketName)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, fmt.Errorf("index `%s` of bucket `%s` is corrupted, key `%s` not exist", indexName, bucketName, key)
+		}
+		expired, err := tx.expired(key, bucketName)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			continue
+		}
+		result = append(result, KeyVal{Key: key, Val: v})
+	}
+	return result, nil
+}
+
+// FindJsonByIndex decode records found by FindByIndex into ret slice
+func (tx *Tx) FindJsonByIndex(bucketName, indexName, value string, ret interface{}) error {
+	data, err := tx.FindByIndex(bucketName, indexName, value)
+	if err != nil {
+		return err
+	}
+	return decodeJsonList(data, ret)
+}
+
+// RebuildIndex drop and build the index from bucket records again, it
+// is useful when an index is added to a bucket which already has records
+func (tx *Tx) RebuildIndex(bucketName, indexName string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.checkBucket(bucketName); err != nil {
+		return err
+	}
+	idx, err := findIndex(bucketName, indexName)
+	if err != nil {
+		return err
+	}
+	return tx.buildIndex(bucketName, idx)
+}
+
+func (tx *Tx) buildIndex(bucketName string, idx Index) error {
+	ib := indexBucket(bucketName, idx.Name)
+	ok, err := tx.tx.bucketExist(ib)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := tx.tx.deleteBucket(ib); err != nil {
+			return err
+		}
+	}
+	if err := tx.tx.createBucket(ib); err != nil {
+		return err
+	}
+
+	c, err := tx.Cursor(bucketName)
+	if err != nil {
+		return err
+	}
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		values, err := indexValues(idx, string(k), v)
+		if err != nil {
+			return err
+		}
+		for value := range values {
+			if err := tx.addIndexEntry(bucketName, idx, value, string(k)); err != nil {
+				return err
+			}
+		}
+	}
+	return cursorErr(c)
+}
+
+// buildMissingIndexes build registered indexes of bucket which are not built yet
+func (tx *Tx) buildMissingIndexes(bucketName string) error {
+	for _, idx := range bucketIndexes(bucketName) {
+		ok, err := tx.tx.bucketExist(indexBucket(bucketName, idx.Name))
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		if err := tx.buildIndex(bucketName, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// compactTxSize is the maximum size of keys and values copied in a compaction transaction
+const compactTxSize = 64 << 20
+
+var (
+	modelsMu sync.RWMutex
+	models   = make(map[string]reflect.Type)
+)
+
+// RegisterModel set the type values of bucket decode into, Check report
+// values which can not be decoded into a new value of model type
+func RegisterModel(bucketName string, model interface{}) {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	models[bucketName] = reflect.TypeOf(model)
+}
+
+// registeredModels return buckets with a registered model, ordered by name
+func registeredModels() ([]string, map[string]reflect.Type) {
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+
+	types := make(map[string]reflect.Type, len(models))
+	var buckets []string
+	for name, t := range models {
+		buckets = append(buckets, name)
+		types[name] = t
+	}
+	sort.Strings(buckets)
+	return buckets, types
+}
+
+// CorruptRecord is a record which can not be decoded into its bucket model
+type CorruptRecord struct {
+	Bucket string
+	Key    string
+	Err    string
+}
+
+// CheckReport is the result of Check
+type CheckReport struct {
+	// Errors are consistency errors of the bbolt file
+	Errors []string
+	// Corrupt are records of buckets with a registered model which can not be decoded
+	Corrupt []CorruptRecord
+	// Records is the number of decoded records
+	Records int
+}
+
+// OK return true if no problem is found
+func (r CheckReport) OK() bool {
+	return len(r.Errors) == 0 && len(r.Corrupt) == 0
+}
+
+// Check run the bbolt consistency check if s is a bolt store, and decode
+// every record of buckets with a registered model. expired records which
+// are not swept yet are checked too
+func Check(s Store) (CheckReport, error) {
+	var report CheckReport
+	if service, ok := s.(*Service); ok {
+		err := service.DB.View(func(tx *bbolt.Tx) error {
+			for err := range tx.Check() {
+				report.Errors = append(report.Errors, err.Error())
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	buckets, types := registeredModels()
+	err := s.View(func(tx *Tx) error {
+		for _, bucketName := range buckets {
+			ok, err := tx.tx.bucketExist(bucketName)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			framed, err := tx.framed(bucketName)
+			if err != nil {
+				return err
+			}
+			// a raw cursor, so checking continue after a corrupt record
+			c, err := tx.tx.cursor(bucketName)
+			if err != nil {
+				return err
+			}
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if err := checkRecord(bucketName, string(k), v, framed, types[bucketName]); err != nil {
+					report.Corrupt = append(report.Corrupt, CorruptRecord{Bucket: bucketName, Key: string(k), Err: err.Error()})
+					continue
+				}
+				report.Records++
+			}
+			if err := cursorErr(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return report, err
+}
+
+func checkRecord(bucketName, key string, value []byte, framed bool, t reflect.Type) error {
+	if framed {
+		var err error
+		if value, err = decodeStored(bucketName, key, value); err != nil {
+			return err
+		}
+	}
+	return decodeValue(value, reflect.New(t).Interface())
+}
+
+// Compact copy all buckets of the bbolt file src into a new file dst, the
+// pages freed by deletes are not copied so dst is usually smaller. src
+// should not be open by a running server and dst should not exist
+func Compact(src, dst string) (srcSize, dstSize int64, err error) {
+	if _, err := os.Stat(dst); err == nil {
+		return 0, 0, fmt.Errorf("%s already exists", dst)
+	}
+	s, err := New(src, WithReadOnly(true), WithTimeout(time.Second))
+	if err != nil {
+		return 0, 0, fmt.Errorf("open %s failed: %s", src, err)
+	}
+	defer s.Close()
+
+	ddb, err := bbolt.Open(dst, 0600, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("create %s failed: %s", dst, err)
+	}
+	if err := compactDB(ddb, s.DB); err != nil {
+		_ = ddb.Close()
+		_ = os.Remove(dst)
+		return 0, 0, err
+	}
+	if err := ddb.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, f := range []struct {
+		path string
+		size *int64
+	}{{src, &srcSize}, {dst, &dstSize}} {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			return 0, 0, err
+		}
+		*f.size = info.Size()
+	}
+	return srcSize, dstSize, nil
+}
+
+// compactDB copy buckets of src into dst in transactions of up to compactTxSize bytes
+func compactDB(dst, src *bbolt.DB) error {
+	return src.View(func(stx *bbolt.Tx) error {
+		return stx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			dtx, err := dst.Begin(true)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = dtx.Rollback() }()
+
+			size := 0
+			bucket, err := dtx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if size+len(k)+len(v) > compactTxSize {
+					if err := dtx.Commit(); err != nil {
+						return err
+					}
+					if dtx, err = dst.Begin(true); err != nil {
+						return err
+					}
+					if bucket = dtx.Bucket(name); bucket == nil {
+						return fmt.Errorf("bucket %s not found after commit", name)
+					}
+					size = 0
+				}
+				if err := bucket.Put(k, v); err != nil {
+					return err
+				}
+				size += len(k) + len(v)
+			}
+			return dtx.Commit()
+		})
+	})
+}
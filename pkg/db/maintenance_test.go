@@ -0,0 +1,120 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+type checkModel struct {
+	Name string `json:"name"`
+}
+
+func init() {
+	RegisterModel("checked", checkModel{})
+}
+
+func TestCheck(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mustCreateBucket(t, s, "checked")
+	mustCreateBucket(t, s, "other")
+	if err := s.SetJson("a", "checked", checkModel{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetJson("b", "checked", checkModel{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	// buckets without a model are not decoded
+	mustSet(t, s, "other", "x", "not json")
+
+	report, err := Check(s)
+	if err != nil || !report.OK() || report.Records != 2 {
+		t.Fatalf("expected a clean report of 2 records but got %+v, %v", report, err)
+	}
+
+	mustSet(t, s, "checked", "c", "not json")
+	mustSet(t, s, "checked", "d", `{"name": 1}`)
+	report, err = Check(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.Records != 2 || len(report.Corrupt) != 2 {
+		t.Fatalf("expected 2 corrupt records but got %+v", report)
+	}
+	if r := report.Corrupt[0]; r.Bucket != "checked" || r.Key != "c" || r.Err == "" {
+		t.Errorf("unexpected corrupt record %+v", r)
+	}
+	if report.Corrupt[1].Key != "d" {
+		t.Errorf("unexpected corrupt record %+v", report.Corrupt[1])
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src.db"), filepath.Join(dir, "dst.db")
+
+	s, err := New(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustCreateBucket(t, s, "test")
+	value := make([]byte, 1024)
+	err = s.Update(func(tx *Tx) error {
+		for i := 0; i < 2000; i++ {
+			if err := tx.Set(fmt.Sprintf("%04d", i), "test", value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Update(func(tx *Tx) error {
+		for i := 10; i < 2000; i++ {
+			if err := tx.Delete(fmt.Sprintf("%04d", i), "test"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srcSize, dstSize, err := Compact(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dstSize >= srcSize {
+		t.Errorf("compacted file should be smaller, %d -> %d", srcSize, dstSize)
+	}
+	if _, _, err := Compact(src, dst); err == nil {
+		t.Error("compact into an existing file should fail")
+	}
+
+	c, err := New(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	all, err := c.GetAll("test")
+	if err != nil || len(all) != 10 {
+		t.Fatalf("expected 10 records after compaction but got %d, %v", len(all), err)
+	}
+	// record versions are copied too
+	if v, err := c.Version("0001", "test"); err != nil || v != 1 {
+		t.Errorf("expected version 1 but got %d, %v", v, err)
+	}
+	report, err := Check(c)
+	if err != nil || !report.OK() {
+		t.Errorf("compacted file should be consistent, got %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,469 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryBackend is the name in-memory store is registered with
+const MemoryBackend = "memory"
+
+// Memory is an in-memory store with the same semantics as bbolt Service,
+// useful for tests and ephemeral deployments. data is lost on close
+type Memory struct {
+	mu       sync.RWMutex
+	closed   bool
+	buckets  map[string]map[string][]byte
+	changes  notifier
+	readOnly readOnlyFlag
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory return an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]map[string][]byte)}
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.buckets = nil
+	return nil
+}
+
+func (m *Memory) ReadOnly() bool {
+	return m.readOnly.get()
+}
+
+func (m *Memory) SetReadOnly(readOnly bool) error {
+	m.readOnly.set(readOnly)
+	return nil
+}
+
+// Update run fn holding the write lock, changes are
+// reverted using an undo log if fn return an error
+func (m *Memory) Update(fn func(tx *Tx) error) error {
+	if m.ReadOnly() {
+		return ErrReadOnly
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("database not open")
+	}
+	mtx := &memoryTx{m: m}
+	committed := false
+	defer func() {
+		// rollback on error or panic
+		if !committed {
+			mtx.rollback()
+		}
+	}()
+
+	if err := fn(&Tx{tx: mtx, writable: true}); err != nil {
+		return err
+	}
+	committed = true
+	m.changes.notify()
+	return nil
+}
+
+func (m *Memory) View(fn func(tx *Tx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return fmt.Errorf("database not open")
+	}
+	return fn(&Tx{tx: &memoryTx{m: m}})
+}
+
+func (m *Memory) Buckets() ([]string, error) {
+	var buckets []string
+	err := m.View(func(tx *Tx) error {
+		var err error
+		buckets, err = tx.Buckets()
+		return err
+	})
+	return buckets, err
+}
+
+func (m *Memory) CreateBucket(bucketName string) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.CreateBucket(bucketName)
+	})
+}
+
+func (m *Memory) IsExist(key, bucketName string) (bool, error) {
+	var exist bool
+	err := m.View(func(tx *Tx) error {
+		var err error
+		exist, err = tx.IsExist(key, bucketName)
+		return err
+	})
+	return exist, err
+}
+
+func (m *Memory) DeleteBucket(bucketName string) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.DeleteBucket(bucketName)
+	})
+}
+
+func (m *Memory) Set(key, bucketName string, value []byte) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.Set(key, bucketName, value)
+	})
+}
+
+func (m *Memory) GetOne(key, bucketName string) ([]byte, error) {
+	var v []byte
+	err := m.View(func(tx *Tx) error {
+		var err error
+		v, err = tx.GetOne(key, bucketName)
+		return err
+	})
+	return v, err
+}
+
+func (m *Memory) GetAll(bucketName string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := m.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetAll(bucketName)
+		return err
+	})
+	return result, err
+}
+
+func (m *Memory) Delete(key, bucketName string) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.Delete(key, bucketName)
+	})
+}
+
+func (m *Memory) BatchDelete(keys []string, bucketName string) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.BatchDelete(keys, bucketName)
+	})
+}
+
+func (m *Memory) SetJson(key, bucketName string, value interface{}) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.SetJson(key, bucketName, value)
+	})
+}
+
+func (m *Memory) GetJson(key, bucketName string, ret interface{}) error {
+	return m.View(func(tx *Tx) error {
+		return tx.GetJson(key, bucketName, ret)
+	})
+}
+
+func (m *Memory) GetJsonList(bucketName string, ret interface{}) error {
+	return m.View(func(tx *Tx) error {
+		return tx.GetJsonList(bucketName, ret)
+	})
+}
+
+func (m *Memory) Version(key, bucketName string) (uint64, error) {
+	var version uint64
+	err := m.View(func(tx *Tx) error {
+		var err error
+		version, err = tx.Version(key, bucketName)
+		return err
+	})
+	return version, err
+}
+
+func (m *Memory) SetIfVersion(key, bucketName string, value []byte, version uint64) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.SetIfVersion(key, bucketName, value, version)
+	})
+}
+
+func (m *Memory) SetJsonIfVersion(key, bucketName string, value interface{}, version uint64) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.SetJsonIfVersion(key, bucketName, value, version)
+	})
+}
+
+func (m *Memory) Scan(bucketName string, opts ScanOptions, fn func(item Item) error) (string, error) {
+	var next string
+	err := m.View(func(tx *Tx) error {
+		var err error
+		next, err = tx.Scan(bucketName, opts, fn)
+		return err
+	})
+	return next, err
+}
+
+func (m *Memory) GetPrefix(bucketName, prefix string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := m.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetPrefix(bucketName, prefix)
+		return err
+	})
+	return result, err
+}
+
+func (m *Memory) GetRange(bucketName, from, to string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := m.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetRange(bucketName, from, to)
+		return err
+	})
+	return result, err
+}
+
+func (m *Memory) GetJsonPrefix(bucketName, prefix string, ret interface{}) error {
+	return m.View(func(tx *Tx) error {
+		return tx.GetJsonPrefix(bucketName, prefix, ret)
+	})
+}
+
+func (m *Memory) GetJsonRange(bucketName, from, to string, ret interface{}) error {
+	return m.View(func(tx *Tx) error {
+		return tx.GetJsonRange(bucketName, from, to, ret)
+	})
+}
+
+func (m *Memory) FindByIndex(bucketName, indexName, value string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := m.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.FindByIndex(bucketName, indexName, value)
+		return err
+	})
+	return result, err
+}
+
+func (m *Memory) FindJsonByIndex(bucketName, indexName, value string, ret interface{}) error {
+	return m.View(func(tx *Tx) error {
+		return tx.FindJsonByIndex(bucketName, indexName, value, ret)
+	})
+}
+
+func (m *Memory) RebuildIndex(bucketName, indexName string) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.RebuildIndex(bucketName, indexName)
+	})
+}
+
+func (m *Memory) Backup(w io.Writer) (int64, error) {
+	return copyBackup(m, w)
+}
+
+func (m *Memory) Stats() (Stats, error) {
+	return keyStats(m, MemoryBackend, "")
+}
+
+func (m *Memory) SetWithTTL(key, bucketName string, value []byte, ttl time.Duration) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.SetWithTTL(key, bucketName, value, ttl)
+	})
+}
+
+func (m *Memory) SetJsonWithTTL(key, bucketName string, value interface{}, ttl time.Duration) error {
+	return m.Update(func(tx *Tx) error {
+		return tx.SetJsonWithTTL(key, bucketName, value, ttl)
+	})
+}
+
+func (m *Memory) TTL(key, bucketName string) (time.Duration, error) {
+	var ttl time.Duration
+	err := m.View(func(tx *Tx) error {
+		var err error
+		ttl, err = tx.TTL(key, bucketName)
+		return err
+	})
+	return ttl, err
+}
+
+func (m *Memory) Watch(ctx context.Context, bucketName string, after uint64) (<-chan Change, error) {
+	return watch(ctx, m, &m.changes, bucketName, after)
+}
+
+// Batch is Update, there is no batching of concurrent writes
+func (m *Memory) Batch(fn func(tx *Tx) error) error {
+	return m.Update(fn)
+}
+
+func (m *Memory) BatchSet(data []KeyVal, bucketName string) (int, error) {
+	return writeChunks(m, data, int(batchChunk.Int64()), func(tx *Tx, chunk []KeyVal) error {
+		return tx.BatchSet(chunk, bucketName)
+	})
+}
+
+func (m *Memory) BatchSetJson(data []JsonKeyVal, bucketName string) (int, error) {
+	return writeChunks(m, data, int(batchChunk.Int64()), func(tx *Tx, chunk []JsonKeyVal) error {
+		return tx.BatchSetJson(chunk, bucketName)
+	})
+}
+
+// memoryTx implement transaction primitives over memory maps,
+// the caller must hold the store lock
+type memoryTx struct {
+	m    *Memory
+	undo []func()
+}
+
+func (t *memoryTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo = nil
+}
+
+func (t *memoryTx) buckets() ([]string, error) {
+	var buckets []string
+	for name := range t.m.buckets {
+		buckets = append(buckets, name)
+	}
+	sort.Strings(buckets)
+	return buckets, nil
+}
+
+func (t *memoryTx) createBucket(bucketName string) error {
+	if _, ok := t.m.buckets[bucketName]; ok {
+		return nil
+	}
+	t.m.buckets[bucketName] = make(map[string][]byte)
+	t.undo = append(t.undo, func() {
+		delete(t.m.buckets, bucketName)
+	})
+	return nil
+}
+
+func (t *memoryTx) deleteBucket(bucketName string) error {
+	b := t.m.buckets[bucketName]
+	delete(t.m.buckets, bucketName)
+	t.undo = append(t.undo, func() {
+		t.m.buckets[bucketName] = b
+	})
+	return nil
+}
+
+func (t *memoryTx) bucketExist(bucketName string) (bool, error) {
+	_, ok := t.m.buckets[bucketName]
+	return ok, nil
+}
+
+func (t *memoryTx) get(key, bucketName string) ([]byte, error) {
+	v, ok := t.m.buckets[bucketName][key]
+	if !ok {
+		return nil, nil
+	}
+	return copyBytes(v), nil
+}
+
+func (t *memoryTx) put(key, bucketName string, value []byte) error {
+	b := t.m.buckets[bucketName]
+	old, existed := b[key]
+	b[key] = copyBytes(value)
+	t.undo = append(t.undo, func() {
+		if existed {
+			b[key] = old
+		} else {
+			delete(b, key)
+		}
+	})
+	return nil
+}
+
+func (t *memoryTx) delete(key, bucketName string) error {
+	b := t.m.buckets[bucketName]
+	old, existed := b[key]
+	if !existed {
+		return nil
+	}
+	delete(b, key)
+	t.undo = append(t.undo, func() {
+		b[key] = old
+	})
+	return nil
+}
+
+func (t *memoryTx) cursor(bucketName string) (Cursor, error) {
+	b := t.m.buckets[bucketName]
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return &memoryCursor{bucket: b, keys: keys, pos: -1}, nil
+}
+
+// memoryCursor iterate over a sorted snapshot of bucket keys
+type memoryCursor struct {
+	bucket map[string][]byte
+	keys   []string
+	pos    int
+}
+
+// item return current item, keys deleted after the cursor is
+// created are skipped moving toward dir
+func (c *memoryCursor) item(dir int) ([]byte, []byte) {
+	for c.pos >= 0 && c.pos < len(c.keys) {
+		k := c.keys[c.pos]
+		if v, ok := c.bucket[k]; ok {
+			return []byte(k), v
+		}
+		c.pos += dir
+	}
+	return nil, nil
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	c.pos = 0
+	return c.item(1)
+}
+
+func (c *memoryCursor) Last() ([]byte, []byte) {
+	c.pos = len(c.keys) - 1
+	return c.item(-1)
+}
+
+func (c *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.pos = sort.Search(len(c.keys), func(i int) bool {
+		return bytes.Compare([]byte(c.keys[i]), seek) >= 0
+	})
+	return c.item(1)
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	if c.pos < len(c.keys) {
+		c.pos++
+	}
+	return c.item(1)
+}
+
+func (c *memoryCursor) Prev() ([]byte, []byte) {
+	if c.pos >= 0 {
+		c.pos--
+	}
+	return c.item(-1)
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+func init() {
+	Register(MemoryBackend, func(path string) (Store, error) {
+		return NewMemory(), nil
+	})
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+)
+
+// Migration is a versioned change of stored data, like changing a model shape
+type Migration struct {
+	// Version order migrations, a timestamp like 202010191200 is recommended
+	// so migrations of different services do not collide
+	Version     int64
+	Description string
+	Up          func(tx *Tx) error
+	// Down revert Up, migration is irreversible if it is nil
+	Down func(tx *Tx) error
+}
+
+// AppliedMigration is the record kept for each applied migration
+type AppliedMigration struct {
+	Version     int64     `json:"version"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// migrationsBucket is the metadata bucket recording applied migrations
+const migrationsBucket = internalPrefix + "migrations"
+
+// errDryRun is used to roll back a dry-run migration
+var errDryRun = errors.New("dry run")
+
+var (
+	migrateManual = config.RegisterBool("db.migrate.manual", false)
+	migrateDryRun = config.RegisterBool("db.migrate.dry_run", false)
+)
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = make(map[int64]Migration)
+)
+
+// RegisterMigration add a migration, it will panic if version is registered twice
+func RegisterMigration(m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	if m.Up == nil {
+		panic(fmt.Sprintf("db: migration %d has no up function", m.Version))
+	}
+	if _, dup := migrations[m.Version]; dup {
+		panic(fmt.Sprintf("db: register called twice for migration %d", m.Version))
+	}
+	migrations[m.Version] = m
+}
+
+// Migrations return registered migrations ordered by version
+func Migrations() []Migration {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+
+	var list []Migration
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+func migrationKey(version int64) string {
+	// zero padded so keys are sorted by version
+	return fmt.Sprintf("%020d", version)
+}
+
+// AppliedMigrations return applied migrations ordered by version
+func (tx *Tx) AppliedMigrations() ([]AppliedMigration, error) {
+	ok, err := tx.tx.bucketExist(migrationsBucket)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var applied []AppliedMigration
+	if err := tx.GetJsonList(migrationsBucket, &applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// MigrationStatus return applied migrations and the registered ones which are not applied yet
+func MigrationStatus(s Store) (applied []AppliedMigration, pending []Migration, err error) {
+	err = s.View(func(tx *Tx) error {
+		applied, err = tx.AppliedMigrations()
+		if err != nil {
+			return err
+		}
+		pending = pendingMigrations(applied)
+		return nil
+	})
+	return applied, pending, err
+}
+
+func pendingMigrations(applied []AppliedMigration) []Migration {
+	done := make(map[int64]bool)
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+	var pending []Migration
+	for _, m := range Migrations() {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// MigrateUp apply pending migrations in order, each one in its own transaction.
+// with dryRun all of them run in a single transaction which is rolled back.
+// a new database, which has no bucket nor applied migration, has no data to
+// migrate, so migrations are only recorded as applied without running them
+func MigrateUp(s Store, dryRun bool) ([]Migration, error) {
+	applied, pending, err := MigrationStatus(s)
+	if err != nil {
+		return nil, err
+	}
+	fresh, err := newDatabase(s, applied)
+	if err != nil {
+		return nil, err
+	}
+	return pending, runMigrations(s, pending, dryRun, func(tx *Tx, m Migration) error {
+		if !fresh {
+			if err := m.Up(tx); err != nil {
+				return fmt.Errorf("migration %d up failed: %s", m.Version, err)
+			}
+		}
+		return recordMigration(tx, m)
+	})
+}
+
+func newDatabase(s Store, applied []AppliedMigration) (bool, error) {
+	if len(applied) > 0 {
+		return false, nil
+	}
+	buckets, err := s.Buckets()
+	if err != nil {
+		return false, err
+	}
+	return len(buckets) == 0, nil
+}
+
+func recordMigration(tx *Tx, m Migration) error {
+	if err := tx.tx.createBucket(migrationsBucket); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(AppliedMigration{
+		Version:     m.Version,
+		Description: m.Description,
+		AppliedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.tx.put(migrationKey(m.Version), migrationsBucket, buf)
+}
+
+// MigrateDown revert the last steps applied migrations in reverse order
+func MigrateDown(s Store, steps int, dryRun bool) ([]Migration, error) {
+	applied, _, err := MigrationStatus(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var revert []Migration
+	for i := len(applied) - 1; i >= 0 && len(revert) < steps; i-- {
+		migrationsMu.RLock()
+		m, ok := migrations[applied[i].Version]
+		migrationsMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("applied migration %d is not registered", applied[i].Version)
+		}
+		if m.Down == nil {
+			return nil, fmt.Errorf("migration %d is irreversible", m.Version)
+		}
+		revert = append(revert, m)
+	}
+
+	return revert, runMigrations(s, revert, dryRun, func(tx *Tx, m Migration) error {
+		if err := m.Down(tx); err != nil {
+			return fmt.Errorf("migration %d down failed: %s", m.Version, err)
+		}
+		return tx.tx.delete(migrationKey(m.Version), migrationsBucket)
+	})
+}
+
+func runMigrations(s Store, list []Migration, dryRun bool, run func(tx *Tx, m Migration) error) error {
+	if dryRun {
+		err := s.Update(func(tx *Tx) error {
+			for _, m := range list {
+				if err := run(tx, m); err != nil {
+					return err
+				}
+			}
+			return errDryRun
+		})
+		if err == errDryRun {
+			return nil
+		}
+		return err
+	}
+
+	for _, m := range list {
+		err := s.Update(func(tx *Tx) error {
+			return run(tx, m)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateOnStart apply pending migrations of the store in ctx. a read-only
+// store, like a bolt file opened next to the server, can not record them so
+// pending migrations are only reported
+func migrateOnStart(ctx *projectx.Ctx) error {
+	if migrateManual.Bool() {
+		return nil
+	}
+	i, ok := ctx.Get(ContextKey)
+	if !ok {
+		return fmt.Errorf("could not get database from context")
+	}
+	s := i.(Store)
+
+	if s.ReadOnly() {
+		_, pending, err := MigrationStatus(s)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			log.Printf("migration %d (%s) is pending, database is read-only", m.Version, m.Description)
+		}
+		return nil
+	}
+
+	dryRun := migrateDryRun.Bool()
+	applied, err := MigrateUp(s, dryRun)
+	if err != nil {
+		return err
+	}
+	for _, m := range applied {
+		if dryRun {
+			log.Printf("migration %d (%s) would be applied", m.Version, m.Description)
+		} else {
+			log.Printf("migration %d (%s) applied", m.Version, m.Description)
+		}
+	}
+	return nil
+}
+
+func init() {
+	// apply pending migrations right after the store is opened
+	registry.Register(migrateOnStart, 1, true)
+}
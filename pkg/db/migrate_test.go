@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+)
+
+func TestMigrate(t *testing.T) {
+	defer func(saved map[int64]Migration) {
+		migrations = saved
+	}(migrations)
+	migrations = make(map[int64]Migration)
+
+	s := NewMemory()
+	mustCreateBucket(t, s, "migrate")
+	mustSet(t, s, "migrate", "a", "1")
+
+	RegisterMigration(Migration{
+		Version:     2,
+		Description: "rename a to b",
+		Up: func(tx *Tx) error {
+			v, err := tx.GetOne("a", "migrate")
+			if err != nil {
+				return err
+			}
+			if err := tx.Set("b", "migrate", v); err != nil {
+				return err
+			}
+			return tx.Delete("a", "migrate")
+		},
+		Down: func(tx *Tx) error {
+			v, err := tx.GetOne("b", "migrate")
+			if err != nil {
+				return err
+			}
+			if err := tx.Set("a", "migrate", v); err != nil {
+				return err
+			}
+			return tx.Delete("b", "migrate")
+		},
+	})
+	RegisterMigration(Migration{
+		Version:     1,
+		Description: "add c",
+		Up: func(tx *Tx) error {
+			return tx.Set("c", "migrate", []byte("3"))
+		},
+	})
+
+	// dry run does not change anything
+	list, err := MigrateUp(s, true)
+	if err != nil {
+		t.Fatalf("dry run failed %s", err)
+	}
+	if len(list) != 2 || list[0].Version != 1 || list[1].Version != 2 {
+		t.Errorf("unexpected migrations %v", list)
+	}
+	if ok, _ := s.IsExist("a", "migrate"); !ok {
+		t.Error("dry run changed data")
+	}
+	applied, pending, _ := MigrationStatus(s)
+	if len(applied) != 0 || len(pending) != 2 {
+		t.Errorf("dry run changed status, %v, %v", applied, pending)
+	}
+
+	if _, err := MigrateUp(s, false); err != nil {
+		t.Fatalf("migrate up failed %s", err)
+	}
+	if ok, _ := s.IsExist("b", "migrate"); !ok {
+		t.Error("migration 2 is not applied")
+	}
+	applied, pending, _ = MigrationStatus(s)
+	if len(applied) != 2 || len(pending) != 0 || applied[1].Description != "rename a to b" {
+		t.Errorf("unexpected status, %v, %v", applied, pending)
+	}
+
+	// nothing left to apply
+	if list, _ := MigrateUp(s, false); len(list) != 0 {
+		t.Errorf("applied migrations ran again, %v", list)
+	}
+
+	list, err = MigrateDown(s, 1, false)
+	if err != nil || len(list) != 1 || list[0].Version != 2 {
+		t.Fatalf("migrate down failed %v, %v", list, err)
+	}
+	if ok, _ := s.IsExist("a", "migrate"); !ok {
+		t.Error("migration 2 is not reverted")
+	}
+
+	if _, err := MigrateDown(s, 1, false); err == nil {
+		t.Error("migrate down should fail on irreversible migration")
+	}
+	if buckets, _ := s.Buckets(); len(buckets) != 1 {
+		t.Errorf("migrations bucket should be hidden, %v", buckets)
+	}
+}
+
+func TestMigrate_NewDatabase(t *testing.T) {
+	defer func(saved map[int64]Migration) {
+		migrations = saved
+	}(migrations)
+	migrations = make(map[int64]Migration)
+
+	RegisterMigration(Migration{
+		Version:     1,
+		Description: "change tasks",
+		Up: func(tx *Tx) error {
+			_, err := tx.GetAll("tasks")
+			return err
+		},
+	})
+
+	// buckets of services are not created yet on a new database
+	s := NewMemory()
+	list, err := MigrateUp(s, false)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("migrate up failed %v, %v", list, err)
+	}
+	applied, pending, _ := MigrationStatus(s)
+	if len(applied) != 1 || len(pending) != 0 {
+		t.Errorf("migrations of a new database should be marked as applied, %v, %v", applied, pending)
+	}
+}
+
+func TestMigrateOnStart_ReadOnly(t *testing.T) {
+	defer func(saved map[int64]Migration) {
+		migrations = saved
+	}(migrations)
+	migrations = make(map[int64]Migration)
+	registry.Flush()
+	defer registry.Flush()
+
+	// a database which has data and a pending migration
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustCreateBucket(t, s, "tasks")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	RegisterMigration(Migration{Version: 1, Up: func(tx *Tx) error { return nil }})
+
+	r, err := New(path, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	registry.Register(func(ctx *projectx.Ctx) error {
+		ctx.Set(ContextKey, r)
+		return nil
+	}, 0, true)
+	registry.Register(migrateOnStart, 1, true)
+
+	if n, errs := registry.Run(projectx.New(context.Background())); n != 2 || len(errs) != 0 {
+		t.Fatalf("registry should start with a read-only store, ran %d, %v", n, errs)
+	}
+	if _, pending, _ := MigrationStatus(r); len(pending) != 1 {
+		t.Errorf("migration should stay pending, got %v", pending)
+	}
+}
+
+func TestRegisterMigrationTwice(t *testing.T) {
+	defer func(saved map[int64]Migration) {
+		migrations = saved
+	}(migrations)
+	migrations = make(map[int64]Migration)
+
+	m := Migration{Version: 1, Up: func(tx *Tx) error { return nil }}
+	RegisterMigration(m)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("register should panic on duplicate version")
+		}
+	}()
+	RegisterMigration(m)
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"go.etcd.io/bbolt"
+)
+
+// ErrLockTimeout is returned by New when the file lock of the database
+// is not acquired in time, usually because another process has it open
+var ErrLockTimeout = errors.New("database is locked by another process")
+
+var (
+	boltTimeout         = config.RegisterString("db.bolt.timeout", "5s")
+	boltReadOnly        = config.RegisterBool("db.bolt.read_only", false)
+	boltNoSync          = config.RegisterBool("db.bolt.no_sync", false)
+	boltNoFreelistSync  = config.RegisterBool("db.bolt.no_freelist_sync", false)
+	boltInitialMmapSize = config.RegisterInt64("db.bolt.initial_mmap_size", 0)
+	boltFileMode        = config.RegisterString("db.bolt.file_mode", "0666")
+)
+
+// Option configure how New open a bbolt file
+type Option func(o *options)
+
+type options struct {
+	mode os.FileMode
+	bolt bbolt.Options
+}
+
+// WithTimeout set how long New wait for the file lock, zero wait forever
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.bolt.Timeout = d
+	}
+}
+
+// WithReadOnly open the file in read-only mode with a shared lock,
+// so several processes can read it at the same time
+func WithReadOnly(readOnly bool) Option {
+	return func(o *options) {
+		o.bolt.ReadOnly = readOnly
+	}
+}
+
+// WithNoSync skip fsync after each commit, it is faster but
+// committed data can be lost if the machine crashes
+func WithNoSync(noSync bool) Option {
+	return func(o *options) {
+		o.bolt.NoSync = noSync
+	}
+}
+
+// WithNoFreelistSync do not write the freelist to disk, commits are faster
+// but opening the file is slower as the freelist is rebuilt
+func WithNoFreelistSync(noFreelistSync bool) Option {
+	return func(o *options) {
+		o.bolt.NoFreelistSync = noFreelistSync
+	}
+}
+
+// WithInitialMmapSize set the initial size of the memory map, a size bigger
+// than the file avoid remapping which blocks writes while read tx are open
+func WithInitialMmapSize(size int) Option {
+	return func(o *options) {
+		o.bolt.InitialMmapSize = size
+	}
+}
+
+// WithFileMode set the permissions the file is created with
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// ConfigOptions return options set by `db.bolt` config
+func ConfigOptions() ([]Option, error) {
+	timeout, err := time.ParseDuration(boltTimeout.String())
+	if err != nil || timeout < 0 {
+		return nil, fmt.Errorf("invalid db.bolt.timeout `%s`", boltTimeout.String())
+	}
+	mode, err := strconv.ParseUint(boltFileMode.String(), 8, 32)
+	if err != nil || mode > 0777 {
+		return nil, fmt.Errorf("invalid db.bolt.file_mode `%s`", boltFileMode.String())
+	}
+	if boltInitialMmapSize.Int64() < 0 {
+		return nil, fmt.Errorf("db.bolt.initial_mmap_size should not be negative")
+	}
+	return []Option{
+		WithTimeout(timeout),
+		WithReadOnly(boltReadOnly.Bool()),
+		WithNoSync(boltNoSync.Bool()),
+		WithNoFreelistSync(boltNoFreelistSync.Bool()),
+		WithInitialMmapSize(int(boltInitialMmapSize.Int64())),
+		WithFileMode(os.FileMode(mode)),
+	}, nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNew_LockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+	_, err = New(path, WithTimeout(100*time.Millisecond))
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected lock timeout error but got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("open should give up after the timeout")
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(path, WithFileMode(0600), WithNoSync(true), WithNoFreelistSync(true), WithInitialMmapSize(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.DB.NoSync || !s.DB.NoFreelistSync {
+		t.Error("sync options are not applied")
+	}
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "a", "1")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected file mode 0600 but got %s", info.Mode().Perm())
+	}
+
+	// read-only stores share the lock
+	r1, err := New(path, WithReadOnly(true), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := New(path, WithReadOnly(true), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	if val, err := r2.GetOne("a", "test"); err != nil || string(val) != "1" {
+		t.Errorf("unexpected value %q, %v", val, err)
+	}
+	if err := r1.Set("b", "test", []byte("2")); err == nil {
+		t.Error("write to a read-only store should fail")
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	opts, err := ConfigOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.bolt.Timeout != 5*time.Second || o.mode != 0666 || o.bolt.ReadOnly {
+		t.Errorf("unexpected default options %+v", o)
+	}
+}
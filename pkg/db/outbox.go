@@ -0,0 +1,446 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+)
+
+// outbox buckets, messages are keyed by big-endian id. outboxDueBucket
+// index pending messages by next attempt, see outboxDueKey
+const (
+	outboxBucket     = internalPrefix + "outbox"
+	deadLetterBucket = internalPrefix + "outbox.dead"
+	outboxDueBucket  = internalPrefix + "outbox.due"
+)
+
+// lastOutboxIDKey hold the last message id, it can not collide with 8 bytes id keys
+const lastOutboxIDKey = "last"
+
+// outboxBatch is the maximum number of messages read in a dispatch round
+const outboxBatch = 100
+
+var (
+	outboxInterval    = config.RegisterString("db.outbox.interval", "1s")
+	outboxMaxAttempts = config.RegisterInt64("db.outbox.max_attempts", 10)
+	outboxBackoff     = config.RegisterString("db.outbox.backoff", "1s")
+	outboxMaxBackoff  = config.RegisterString("db.outbox.max_backoff", "10m")
+)
+
+// OutboxMessage is a side effect of a transaction, like a notification, it is
+// stored in the same transaction as the change it belongs to and delivered
+// to the handler of its topic after the transaction is committed
+type OutboxMessage struct {
+	ID      uint64          `json:"id"`
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is the number of failed deliveries
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// OutboxHandler deliver a message, a message is delivered at least once
+// so handlers should be idempotent. a returned error schedule a retry
+type OutboxHandler func(ctx context.Context, msg OutboxMessage) error
+
+var (
+	outboxHandlersMu sync.RWMutex
+	outboxHandlers   = make(map[string]OutboxHandler)
+)
+
+// RegisterOutboxHandler set the handler of messages of topic,
+// it will panic if topic is registered twice
+func RegisterOutboxHandler(topic string, handler OutboxHandler) {
+	outboxHandlersMu.Lock()
+	defer outboxHandlersMu.Unlock()
+
+	if _, dup := outboxHandlers[topic]; dup {
+		panic(fmt.Sprintf("db: register called twice for outbox topic %s", topic))
+	}
+	outboxHandlers[topic] = handler
+}
+
+func outboxHandler(topic string) (OutboxHandler, bool) {
+	outboxHandlersMu.RLock()
+	defer outboxHandlersMu.RUnlock()
+	h, ok := outboxHandlers[topic]
+	return h, ok
+}
+
+// OutboxPolicy control retries of failed deliveries
+type OutboxPolicy struct {
+	// MaxAttempts is the number of deliveries before a message is dead-lettered
+	MaxAttempts int
+	// Backoff is the delay after the first failure, it is doubled after
+	// each failure up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultOutboxPolicy match the default `db.outbox` config
+var DefaultOutboxPolicy = OutboxPolicy{MaxAttempts: 10, Backoff: time.Second, MaxBackoff: 10 * time.Minute}
+
+// delay return the wait before the next delivery of a message failed attempts times
+func (p OutboxPolicy) delay(attempts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempts && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// loadOutboxPolicy read the policy from `db.outbox` config
+func loadOutboxPolicy() (OutboxPolicy, error) {
+	p := OutboxPolicy{MaxAttempts: int(outboxMaxAttempts.Int64())}
+	var err error
+	if p.Backoff, err = time.ParseDuration(outboxBackoff.String()); err != nil || p.Backoff <= 0 {
+		return p, fmt.Errorf("invalid db.outbox.backoff `%s`", outboxBackoff.String())
+	}
+	if p.MaxBackoff, err = time.ParseDuration(outboxMaxBackoff.String()); err != nil || p.MaxBackoff < p.Backoff {
+		return p, fmt.Errorf("invalid db.outbox.max_backoff `%s`", outboxMaxBackoff.String())
+	}
+	if p.MaxAttempts <= 0 {
+		return p, fmt.Errorf("db.outbox.max_attempts should be positive")
+	}
+	return p, nil
+}
+
+func outboxKey(id uint64) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, id)
+	return string(buf)
+}
+
+// Enqueue add a message of topic to the outbox, payload is encoded as JSON.
+// the message is delivered only if tx is committed
+func (tx *Tx) Enqueue(topic string, payload interface{}) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := tx.tx.createBucket(outboxBucket); err != nil {
+		return err
+	}
+	var last uint64
+	v, err := tx.tx.get(lastOutboxIDKey, outboxBucket)
+	if err != nil {
+		return err
+	}
+	if len(v) == 8 {
+		last = binary.BigEndian.Uint64(v)
+	}
+
+	current := now().UTC()
+	msg := OutboxMessage{
+		ID:          last + 1,
+		Topic:       topic,
+		Payload:     buf,
+		CreatedAt:   current,
+		NextAttempt: current,
+	}
+	if err := tx.putOutboxMessage(outboxBucket, msg); err != nil {
+		return err
+	}
+	return tx.tx.put(lastOutboxIDKey, outboxBucket, []byte(outboxKey(msg.ID)))
+}
+
+// outboxDueKey is big-endian next attempt followed by big-endian id,
+// so due messages are first in outboxDueBucket
+func outboxDueKey(msg OutboxMessage) string {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, uint64(msg.NextAttempt.UnixNano()))
+	binary.BigEndian.PutUint64(buf[8:], msg.ID)
+	return string(buf)
+}
+
+// putOutboxMessage store msg in an outbox bucket, pending messages are indexed too
+func (tx *Tx) putOutboxMessage(bucketName string, msg OutboxMessage) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := tx.tx.createBucket(bucketName); err != nil {
+		return err
+	}
+	if err := tx.tx.put(outboxKey(msg.ID), bucketName, buf); err != nil {
+		return err
+	}
+	if bucketName != outboxBucket {
+		return nil
+	}
+	if err := tx.tx.createBucket(outboxDueBucket); err != nil {
+		return err
+	}
+	return tx.tx.put(outboxDueKey(msg), outboxDueBucket, []byte(outboxKey(msg.ID)))
+}
+
+// indexOutbox index pending messages which are enqueued before outboxDueBucket
+// existed, it does nothing when the index exists
+func indexOutbox(s Store) error {
+	var indexed bool
+	err := s.View(func(tx *Tx) error {
+		var err error
+		indexed, err = tx.tx.bucketExist(outboxDueBucket)
+		if err != nil || indexed {
+			return err
+		}
+		ok, err := tx.tx.bucketExist(outboxBucket)
+		indexed = !ok
+		return err
+	})
+	if err != nil || indexed {
+		return err
+	}
+	return s.Update(func(tx *Tx) error {
+		if err := tx.tx.createBucket(outboxDueBucket); err != nil {
+			return err
+		}
+		pending, err := tx.Outbox(0)
+		if err != nil {
+			return err
+		}
+		for _, msg := range pending {
+			if err := tx.tx.put(outboxDueKey(msg), outboxDueBucket, []byte(outboxKey(msg.ID))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dueOutbox return up to limit pending messages which are due at current,
+// ordered by next attempt
+func (tx *Tx) dueOutbox(limit int, current time.Time) ([]OutboxMessage, error) {
+	ok, err := tx.tx.bucketExist(outboxDueBucket)
+	if err != nil || !ok {
+		return nil, err
+	}
+	c, err := tx.tx.cursor(outboxDueBucket)
+	if err != nil {
+		return nil, err
+	}
+	end := uint64(current.UnixNano())
+	var list []OutboxMessage
+	for k, v := c.First(); k != nil && len(list) < limit; k, v = c.Next() {
+		if len(k) != 16 || binary.BigEndian.Uint64(k) > end {
+			break
+		}
+		buf, err := tx.tx.get(string(v), outboxBucket)
+		if err != nil {
+			return nil, err
+		}
+		if buf == nil {
+			continue
+		}
+		var msg OutboxMessage
+		if err := json.Unmarshal(buf, &msg); err != nil {
+			return nil, fmt.Errorf("outbox message %x is corrupted: %s", v, err)
+		}
+		list = append(list, msg)
+	}
+	return list, cursorErr(c)
+}
+
+// outboxMessages return up to limit messages of an outbox bucket, ordered by id
+func (tx *Tx) outboxMessages(bucketName string, limit int) ([]OutboxMessage, error) {
+	ok, err := tx.tx.bucketExist(bucketName)
+	if err != nil || !ok {
+		return nil, err
+	}
+	c, err := tx.tx.cursor(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var list []OutboxMessage
+	for k, v := c.First(); k != nil && (limit <= 0 || len(list) < limit); k, v = c.Next() {
+		if len(k) != 8 {
+			break
+		}
+		var msg OutboxMessage
+		if err := json.Unmarshal(v, &msg); err != nil {
+			return nil, fmt.Errorf("outbox message %x is corrupted: %s", k, err)
+		}
+		list = append(list, msg)
+	}
+	return list, cursorErr(c)
+}
+
+// Outbox return up to limit pending messages, zero limit mean no limit
+func (tx *Tx) Outbox(limit int) ([]OutboxMessage, error) {
+	return tx.outboxMessages(outboxBucket, limit)
+}
+
+// DeadLetters return up to limit messages which are not delivered after
+// the maximum attempts, zero limit mean no limit
+func (tx *Tx) DeadLetters(limit int) ([]OutboxMessage, error) {
+	return tx.outboxMessages(deadLetterBucket, limit)
+}
+
+// RequeueDeadLetter move a dead-lettered message back to the outbox with no attempts
+func (tx *Tx) RequeueDeadLetter(id uint64) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	ok, err := tx.tx.bucketExist(deadLetterBucket)
+	if err != nil {
+		return err
+	}
+	var v []byte
+	if ok {
+		if v, err = tx.tx.get(outboxKey(id), deadLetterBucket); err != nil {
+			return err
+		}
+	}
+	if v == nil {
+		return fmt.Errorf("%w: dead letter %d", ErrNotFound, id)
+	}
+	var msg OutboxMessage
+	if err := json.Unmarshal(v, &msg); err != nil {
+		return err
+	}
+	msg.Attempts, msg.LastError, msg.NextAttempt = 0, "", now().UTC()
+	if err := tx.putOutboxMessage(outboxBucket, msg); err != nil {
+		return err
+	}
+	return tx.tx.delete(outboxKey(id), deadLetterBucket)
+}
+
+// deliver call handler of msg topic, a panic of handler is returned as an error
+func deliver(ctx context.Context, msg OutboxMessage) (err error) {
+	handler, ok := outboxHandler(msg.Topic)
+	if !ok {
+		return fmt.Errorf("no handler for topic `%s`", msg.Topic)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+// DispatchOutbox deliver due outbox messages, delivered messages are removed,
+// failed ones are retried with backoff and moved to dead letters after
+// policy.MaxAttempts. it return the number of delivered messages. nothing
+// is delivered in read-only mode, as results could not be recorded
+func DispatchOutbox(ctx context.Context, s Store, policy OutboxPolicy) (int, error) {
+	if s.ReadOnly() {
+		return 0, nil
+	}
+	if err := indexOutbox(s); err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for {
+		// only due messages are read, messages backing off are not scanned
+		var due []OutboxMessage
+		err := s.View(func(tx *Tx) error {
+			var err error
+			due, err = tx.dueOutbox(outboxBatch, now())
+			return err
+		})
+		if err != nil {
+			return delivered, err
+		}
+
+		for _, msg := range due {
+			if ctx.Err() != nil {
+				return delivered, ctx.Err()
+			}
+			// handlers run outside of transactions, the result is recorded after
+			deliverErr := deliver(ctx, msg)
+			err := s.Update(func(tx *Tx) error {
+				return tx.settleOutboxMessage(msg, deliverErr, policy)
+			})
+			if err != nil {
+				return delivered, err
+			}
+			if deliverErr == nil {
+				delivered++
+			} else {
+				log.Printf("deliver outbox message %d of topic %s failed: %s", msg.ID, msg.Topic, deliverErr)
+			}
+		}
+		if len(due) < outboxBatch {
+			return delivered, nil
+		}
+	}
+}
+
+// settleOutboxMessage record result of a delivery of msg
+func (tx *Tx) settleOutboxMessage(msg OutboxMessage, deliverErr error, policy OutboxPolicy) error {
+	if err := tx.tx.delete(outboxKey(msg.ID), outboxBucket); err != nil {
+		return err
+	}
+	if err := tx.tx.delete(outboxDueKey(msg), outboxDueBucket); err != nil {
+		return err
+	}
+	if deliverErr == nil {
+		return nil
+	}
+	msg.Attempts++
+	msg.LastError = deliverErr.Error()
+	if msg.Attempts >= policy.MaxAttempts {
+		return tx.putOutboxMessage(deadLetterBucket, msg)
+	}
+	msg.NextAttempt = now().Add(policy.delay(msg.Attempts)).UTC()
+	return tx.putOutboxMessage(outboxBucket, msg)
+}
+
+// scheduleOutbox dispatch outbox messages every interval until ctx is done
+func scheduleOutbox(ctx *projectx.Ctx, s Store, interval time.Duration, policy OutboxPolicy) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := DispatchOutbox(ctx.Context(), s, policy); err != nil && ctx.Context().Err() == nil {
+				log.Printf("dispatch outbox failed: %s", err)
+			}
+		}
+	}
+}
+
+func init() {
+	// start the outbox dispatcher, zero interval disable it
+	registry.Register(func(ctx *projectx.Ctx) error {
+		interval, err := time.ParseDuration(outboxInterval.String())
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid db.outbox.interval `%s`", outboxInterval.String())
+		}
+		if interval == 0 {
+			return nil
+		}
+		policy, err := loadOutboxPolicy()
+		if err != nil {
+			return err
+		}
+		i, ok := ctx.Get(ContextKey)
+		if !ok {
+			return fmt.Errorf("could not get database from context")
+		}
+		ctx.Go(func() {
+			scheduleOutbox(ctx, i.(Store), interval, policy)
+		})
+		return nil
+	}, 2, false)
+}
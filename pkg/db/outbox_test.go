@@ -0,0 +1,247 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	outboxDelivered []string
+	outboxFailures  int
+)
+
+func init() {
+	RegisterOutboxHandler("test.ok", func(ctx context.Context, msg OutboxMessage) error {
+		outboxDelivered = append(outboxDelivered, string(msg.Payload))
+		return nil
+	})
+	RegisterOutboxHandler("test.flaky", func(ctx context.Context, msg OutboxMessage) error {
+		if outboxFailures > 0 {
+			outboxFailures--
+			return errors.New("unavailable")
+		}
+		outboxDelivered = append(outboxDelivered, string(msg.Payload))
+		return nil
+	})
+	RegisterOutboxHandler("test.panic", func(ctx context.Context, msg OutboxMessage) error {
+		panic("boom")
+	})
+}
+
+func pendingOutbox(t *testing.T, s Store) []OutboxMessage {
+	t.Helper()
+	var list []OutboxMessage
+	err := s.View(func(tx *Tx) error {
+		var err error
+		list, err = tx.Outbox(0)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestOutboxSameTx(t *testing.T) {
+	outboxDelivered = nil
+	s := NewMemory()
+	mustCreateBucket(t, s, "test")
+
+	// a rolled back tx enqueue nothing
+	err := s.Update(func(tx *Tx) error {
+		if err := tx.Set("a", "test", []byte("1")); err != nil {
+			return err
+		}
+		if err := tx.Enqueue("test.ok", "a"); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatal("update should fail")
+	}
+	if list := pendingOutbox(t, s); len(list) != 0 {
+		t.Fatalf("rolled back message should not be stored, got %v", list)
+	}
+
+	err = s.Update(func(tx *Tx) error {
+		if err := tx.Set("a", "test", []byte("1")); err != nil {
+			return err
+		}
+		if err := tx.Enqueue("test.ok", "a"); err != nil {
+			return err
+		}
+		return tx.Enqueue("test.ok", "b")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := pendingOutbox(t, s); len(list) != 2 || list[0].ID != 1 || list[1].ID != 2 {
+		t.Fatalf("expected 2 pending messages but got %v", list)
+	}
+
+	n, err := DispatchOutbox(context.Background(), s, DefaultOutboxPolicy)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 delivered messages but got %d, %v", n, err)
+	}
+	if len(outboxDelivered) != 2 || outboxDelivered[0] != `"a"` || outboxDelivered[1] != `"b"` {
+		t.Errorf("unexpected delivered payloads %v", outboxDelivered)
+	}
+	if list := pendingOutbox(t, s); len(list) != 0 {
+		t.Errorf("delivered messages should be removed, got %v", list)
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	advance := setClock(t)
+	outboxDelivered, outboxFailures = nil, 2
+	s := NewMemory()
+	policy := OutboxPolicy{MaxAttempts: 5, Backoff: time.Second, MaxBackoff: time.Minute}
+
+	if err := s.Update(func(tx *Tx) error { return tx.Enqueue("test.flaky", 1) }); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := DispatchOutbox(context.Background(), s, policy); err != nil || n != 0 {
+		t.Fatalf("failed delivery should not be counted, got %d, %v", n, err)
+	}
+	list := pendingOutbox(t, s)
+	if len(list) != 1 || list[0].Attempts != 1 || list[0].LastError != "unavailable" {
+		t.Fatalf("failed message should be kept for retry, got %v", list)
+	}
+
+	// not due before backoff
+	if n, _ := DispatchOutbox(context.Background(), s, policy); n != 0 || outboxFailures != 1 {
+		t.Fatal("message should not be delivered before its backoff")
+	}
+	advance(time.Second)
+	if _, err := DispatchOutbox(context.Background(), s, policy); err != nil {
+		t.Fatal(err)
+	}
+	// second failure double the backoff
+	advance(time.Second)
+	if n, _ := DispatchOutbox(context.Background(), s, policy); n != 0 {
+		t.Fatal("message should not be delivered before its backoff")
+	}
+	advance(time.Second)
+	if n, err := DispatchOutbox(context.Background(), s, policy); err != nil || n != 1 {
+		t.Fatalf("expected message to be delivered, got %d, %v", n, err)
+	}
+	if len(outboxDelivered) != 1 {
+		t.Errorf("unexpected delivered payloads %v", outboxDelivered)
+	}
+}
+
+func TestOutboxDue(t *testing.T) {
+	advance := setClock(t)
+	outboxDelivered, outboxFailures = nil, outboxBatch+10
+	s := NewMemory()
+	policy := OutboxPolicy{MaxAttempts: 5, Backoff: time.Minute, MaxBackoff: time.Minute}
+
+	err := s.Update(func(tx *Tx) error {
+		for i := 0; i < outboxBatch+10; i++ {
+			if err := tx.Enqueue("test.flaky", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := DispatchOutbox(context.Background(), s, policy); err != nil || n != 0 || outboxFailures != 0 {
+		t.Fatalf("every message should fail once, got %d, %v, %d left", n, err, outboxFailures)
+	}
+
+	// messages backing off are not read
+	if err := s.Update(func(tx *Tx) error { return tx.Enqueue("test.ok", "due") }); err != nil {
+		t.Fatal(err)
+	}
+	err = s.View(func(tx *Tx) error {
+		due, err := tx.dueOutbox(outboxBatch, now())
+		if err == nil && (len(due) != 1 || due[0].Topic != "test.ok") {
+			t.Errorf("only the new message should be due, got %d messages", len(due))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := DispatchOutbox(context.Background(), s, policy); err != nil || n != 1 {
+		t.Fatalf("expected due message to be delivered, got %d, %v", n, err)
+	}
+
+	// messages stored before the index existed are indexed once
+	if err := s.Update(func(tx *Tx) error { return tx.tx.deleteBucket(outboxDueBucket) }); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Minute)
+	if n, err := DispatchOutbox(context.Background(), s, policy); err != nil || n != outboxBatch+10 {
+		t.Fatalf("expected unindexed messages to be delivered, got %d, %v", n, err)
+	}
+	if list := pendingOutbox(t, s); len(list) != 0 {
+		t.Errorf("delivered messages should be removed, got %d", len(list))
+	}
+}
+
+func TestOutboxDeadLetter(t *testing.T) {
+	advance := setClock(t)
+	s := NewMemory()
+	policy := OutboxPolicy{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Second}
+
+	err := s.Update(func(tx *Tx) error {
+		if err := tx.Enqueue("test.panic", nil); err != nil {
+			return err
+		}
+		return tx.Enqueue("test.unknown", nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := DispatchOutbox(context.Background(), s, policy); err != nil {
+			t.Fatal(err)
+		}
+		advance(time.Second)
+	}
+	if list := pendingOutbox(t, s); len(list) != 0 {
+		t.Fatalf("messages should be dead-lettered, got %v", list)
+	}
+
+	var dead []OutboxMessage
+	err = s.View(func(tx *Tx) error {
+		var err error
+		dead, err = tx.DeadLetters(0)
+		return err
+	})
+	if err != nil || len(dead) != 2 {
+		t.Fatalf("expected 2 dead letters but got %v, %v", dead, err)
+	}
+	if dead[0].Attempts != 3 || dead[0].LastError != "handler panic: boom" {
+		t.Errorf("unexpected dead letter %+v", dead[0])
+	}
+	if dead[1].LastError != "no handler for topic `test.unknown`" {
+		t.Errorf("unexpected dead letter %+v", dead[1])
+	}
+
+	if err := s.Update(func(tx *Tx) error { return tx.RequeueDeadLetter(dead[1].ID) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(func(tx *Tx) error { return tx.RequeueDeadLetter(dead[1].ID) }); err == nil {
+		t.Error("requeue of a missing dead letter should fail")
+	}
+	if list := pendingOutbox(t, s); len(list) != 1 || list[0].ID != 2 || list[0].Attempts != 0 {
+		t.Errorf("requeued message should be pending with no attempts, got %v", list)
+	}
+}
+
+func TestOutboxPolicyDelay(t *testing.T) {
+	p := OutboxPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if got := p.delay(i + 1); got != d {
+			t.Errorf("delay of attempt %d should be %s but got %s", i+1, d, got)
+		}
+	}
+}
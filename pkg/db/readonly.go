@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+)
+
+// ErrReadOnly is returned by writes to a store in read-only mode
+var ErrReadOnly = errors.New("database is read-only")
+
+// startReadOnly put the server store in maintenance read-only mode at startup,
+// unlike `db.bolt.read_only` the file is still owned by the server
+var startReadOnly = config.RegisterBool("db.read_only", false)
+
+// readOnlyFlag is the maintenance read-only switch of a store, zero value is writable
+type readOnlyFlag struct {
+	v int32
+}
+
+func (f *readOnlyFlag) set(readOnly bool) {
+	var v int32
+	if readOnly {
+		v = 1
+	}
+	atomic.StoreInt32(&f.v, v)
+}
+
+func (f *readOnlyFlag) get() bool {
+	return atomic.LoadInt32(&f.v) == 1
+}
+
+// Snapshot write a consistent copy of s to file and open it read-only,
+// so reports can read it without holding transactions of s open.
+// the copy is not updated, take a new snapshot to see new writes
+func Snapshot(s Store, file string) (*Service, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := s.Backup(tmp); err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return nil, err
+	}
+	return New(file, WithReadOnly(true), WithTimeout(time.Second))
+}
+
+func init() {
+	// enter maintenance read-only mode after migrations are applied
+	// and services have created their buckets
+	registry.Register(func(ctx *projectx.Ctx) error {
+		if !startReadOnly.Bool() {
+			return nil
+		}
+		i, ok := ctx.Get(ContextKey)
+		if !ok {
+			return fmt.Errorf("could not get database from context")
+		}
+		return i.(Store).SetReadOnly(true)
+	}, 9, true)
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStoreReadOnly(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "a", "1")
+	if err := s.SetReadOnly(true); err != nil {
+		t.Fatal(err)
+	}
+	if !s.ReadOnly() {
+		t.Fatal("store should be read-only")
+	}
+
+	writes := map[string]func() error{
+		"Set":          func() error { return s.Set("b", "test", []byte("2")) },
+		"SetJson":      func() error { return s.SetJson("b", "test", 2) },
+		"SetWithTTL":   func() error { return s.SetWithTTL("b", "test", []byte("2"), time.Minute) },
+		"Delete":       func() error { return s.Delete("a", "test") },
+		"BatchDelete":  func() error { return s.BatchDelete([]string{"a"}, "test") },
+		"CreateBucket": func() error { return s.CreateBucket("other") },
+		"DeleteBucket": func() error { return s.DeleteBucket("test") },
+		"Update":       func() error { return s.Update(func(tx *Tx) error { return nil }) },
+	}
+	for name, write := range writes {
+		if err := write(); err != ErrReadOnly {
+			t.Errorf("%s should fail with ErrReadOnly but got %v", name, err)
+		}
+	}
+	if val, err := s.GetOne("a", "test"); err != nil || string(val) != "1" {
+		t.Errorf("reads should work in read-only mode, got %q, %v", val, err)
+	}
+	if n, err := SweepExpired(s); err != nil || n != 0 {
+		t.Errorf("sweep should be skipped in read-only mode, got %d, %v", n, err)
+	}
+
+	if err := s.SetReadOnly(false); err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, s, "test", "b", "2")
+}
+
+func TestService_ReadOnlyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustCreateBucket(t, s, "test")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(path, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !r.ReadOnly() {
+		t.Error("store opened read-only should report it")
+	}
+	if err := r.Set("a", "test", []byte("1")); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly but got %v", err)
+	}
+	if err := r.SetReadOnly(false); err != ErrReadOnly {
+		t.Errorf("store opened read-only should not become writable, got %v", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s := NewMemory()
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "a", "1")
+
+	file := filepath.Join(t.TempDir(), "snapshot.db")
+	snap, err := Snapshot(s, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	mustSet(t, s, "test", "b", "2")
+	all, err := snap.GetAll("test")
+	if err != nil || len(all) != 1 || all[0].Key != "a" {
+		t.Errorf("snapshot should hold data at the time it is taken, got %v, %v", all, err)
+	}
+	if err := snap.Set("c", "test", []byte("3")); err != ErrReadOnly {
+		t.Errorf("snapshot should be read-only, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrStopScan can be returned by a Scan callback to stop iteration without error
+var ErrStopScan = errors.New("stop scan")
+
+// ScanOptions control which records Scan visit and in what order
+type ScanOptions struct {
+	// After start the scan right after this key, empty mean from the first
+	// key, or from the last one in reverse order
+	After string
+	// Limit is the maximum number of records to visit, zero mean no limit
+	Limit int
+	// Reverse visit records in descending key order
+	Reverse bool
+	// Prefix limit the scan to keys starting with prefix
+	Prefix string
+	// From and To limit the scan to keys in [From, To) range,
+	// empty From or To mean the range is open on that side
+	From string
+	To   string
+}
+
+// bounds return the [lower, upper) key range the options allow,
+// an empty upper mean there is no upper bound
+func (o ScanOptions) bounds() (lower, upper string) {
+	lower, upper = o.From, o.To
+	if o.Prefix != "" {
+		if o.Prefix > lower {
+			lower = o.Prefix
+		}
+		if end := prefixEnd(o.Prefix); end != "" && (upper == "" || end < upper) {
+			upper = end
+		}
+	}
+	return lower, upper
+}
+
+// prefixEnd return the smallest key greater than all keys with the given
+// prefix, or empty if there is no such key (prefix is all 0xff)
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// KeySeparator is used by JoinKey to build composite keys
+const KeySeparator = "/"
+
+// JoinKey build a composite key like `sprint/<id>/task/<id>`, records
+// sharing a key prefix can then be listed with a prefix scan
+func JoinKey(parts ...string) string {
+	return strings.Join(parts, KeySeparator)
+}
+
+// Item is a record visited by Scan, Value is only valid inside the callback
+type Item struct {
+	Key   string
+	Value []byte
+}
+
+// Json decode item value with its codec into ret
+func (i Item) Json(ret interface{}) error {
+	return decodeValue(i.Value, ret)
+}
+
+// Scan call fn for records of bucket one by one without loading the whole
+// bucket, next is the key to continue from (as After) when there are more
+// records left, and is empty when the scan reached the end of bucket or range
+func (tx *Tx) Scan(bucketName string, opts ScanOptions, fn func(item Item) error) (next string, err error) {
+	c, err := tx.Cursor(bucketName)
+	if err != nil {
+		return "", err
+	}
+
+	lower, upper := opts.bounds()
+	inRange := func(k []byte) bool {
+		return k != nil && string(k) >= lower && (upper == "" || string(k) < upper)
+	}
+
+	var k, v []byte
+	step := c.Next
+	if opts.Reverse {
+		step = c.Prev
+		// start from the largest key before both After and upper
+		before := upper
+		if opts.After != "" && (before == "" || opts.After < before) {
+			before = opts.After
+		}
+		k, v = seekBefore(c, before)
+	} else if opts.After != "" && opts.After >= lower {
+		k, v = seekAfter(c, []byte(opts.After))
+	} else {
+		k, v = c.Seek([]byte(lower))
+	}
+
+	count := 0
+	var last string
+	for ; inRange(k); k, v = step() {
+		if opts.Limit > 0 && count == opts.Limit {
+			// there is at least one more record
+			return last, nil
+		}
+		last = string(k)
+		count++
+		if err := fn(Item{Key: last, Value: v}); err != nil {
+			if err == ErrStopScan {
+				// continue from next record, if there is any
+				if k, _ = step(); !inRange(k) {
+					return "", cursorErr(c)
+				}
+				return last, cursorErr(c)
+			}
+			return "", err
+		}
+	}
+	return "", cursorErr(c)
+}
+
+// seekAfter move cursor to the first key greater than seek
+func seekAfter(c Cursor, seek []byte) ([]byte, []byte) {
+	k, v := c.Seek(seek)
+	if k != nil && string(k) == string(seek) {
+		return c.Next()
+	}
+	return k, v
+}
+
+// seekBefore move cursor to the last key less than seek, or to
+// the last key of bucket if seek is empty
+func seekBefore(c Cursor, seek string) ([]byte, []byte) {
+	if seek == "" {
+		return c.Last()
+	}
+	if k, _ := c.Seek([]byte(seek)); k == nil {
+		// seek is after last key
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+// GetPrefix return all records with keys starting with prefix
+func (tx *Tx) GetPrefix(bucketName, prefix string) ([]KeyVal, error) {
+	return tx.getScan(bucketName, ScanOptions{Prefix: prefix})
+}
+
+// GetRange return all records with keys in [from, to) range
+func (tx *Tx) GetRange(bucketName, from, to string) ([]KeyVal, error) {
+	return tx.getScan(bucketName, ScanOptions{From: from, To: to})
+}
+
+// GetJsonPrefix decode records with keys starting with prefix into ret slice
+func (tx *Tx) GetJsonPrefix(bucketName, prefix string, ret interface{}) error {
+	data, err := tx.GetPrefix(bucketName, prefix)
+	if err != nil {
+		return err
+	}
+	return decodeJsonList(data, ret)
+}
+
+// GetJsonRange decode records with keys in [from, to) range into ret slice
+func (tx *Tx) GetJsonRange(bucketName, from, to string, ret interface{}) error {
+	data, err := tx.GetRange(bucketName, from, to)
+	if err != nil {
+		return err
+	}
+	return decodeJsonList(data, ret)
+}
+
+func (tx *Tx) getScan(bucketName string, opts ScanOptions) ([]KeyVal, error) {
+	var result []KeyVal
+	_, err := tx.Scan(bucketName, opts, func(item Item) error {
+		result = append(result, KeyVal{
+			Key: item.Key,
+			Val: copyBytes(item.Value),
+		})
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,514 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	// pure go sqlite driver, no cgo needed
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteBackend is the name sqlite store is registered with
+const SQLiteBackend = "sqlite"
+
+// SQLite is a store backed by a sqlite database, every bucket is a table
+// with `key` and `value` columns so data can be queried with plain sql, e.g.
+//
+//	SELECT key, json_extract(CAST(value AS TEXT), '$.assignee') FROM tasks
+//
+// transactions share a single connection, so fn of View and Update should
+// use its tx and not call methods of the store, a nested call wait for
+// the connection forever
+type SQLite struct {
+	path     string
+	DB       *sql.DB
+	changes  notifier
+	readOnly readOnlyFlag
+}
+
+var _ Store = (*SQLite)(nil)
+
+// NewSQLite open or create a sqlite database in the given path
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allow a single writer, also each connection of an
+	// in-memory database is a separate database.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLite{
+		path: path,
+		DB:   db,
+	}, nil
+}
+
+func (s *SQLite) Close() error {
+	return s.DB.Close()
+}
+
+func (s *SQLite) ReadOnly() bool {
+	return s.readOnly.get()
+}
+
+func (s *SQLite) SetReadOnly(readOnly bool) error {
+	s.readOnly.set(readOnly)
+	return nil
+}
+
+func (s *SQLite) Update(fn func(tx *Tx) error) error {
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
+	err := s.run(true, fn)
+	if err == nil {
+		s.changes.notify()
+	}
+	return err
+}
+
+func (s *SQLite) View(fn func(tx *Tx) error) error {
+	return s.run(false, fn)
+}
+
+func (s *SQLite) run(writable bool, fn func(tx *Tx) error) error {
+	tx, err := s.DB.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: !writable})
+	if err != nil {
+		return err
+	}
+	// no-op if committed
+	defer tx.Rollback()
+
+	if err := fn(&Tx{tx: sqliteTx{tx: tx}, writable: writable}); err != nil {
+		return err
+	}
+	if !writable {
+		return nil
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) Buckets() ([]string, error) {
+	var buckets []string
+	err := s.View(func(tx *Tx) error {
+		var err error
+		buckets, err = tx.Buckets()
+		return err
+	})
+	return buckets, err
+}
+
+func (s *SQLite) CreateBucket(bucketName string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.CreateBucket(bucketName)
+	})
+}
+
+func (s *SQLite) IsExist(key, bucketName string) (bool, error) {
+	var exist bool
+	err := s.View(func(tx *Tx) error {
+		var err error
+		exist, err = tx.IsExist(key, bucketName)
+		return err
+	})
+	return exist, err
+}
+
+func (s *SQLite) DeleteBucket(bucketName string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.DeleteBucket(bucketName)
+	})
+}
+
+func (s *SQLite) Set(key, bucketName string, value []byte) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.Set(key, bucketName, value)
+	})
+}
+
+func (s *SQLite) GetOne(key, bucketName string) ([]byte, error) {
+	var v []byte
+	err := s.View(func(tx *Tx) error {
+		var err error
+		v, err = tx.GetOne(key, bucketName)
+		return err
+	})
+	return v, err
+}
+
+func (s *SQLite) GetAll(bucketName string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := s.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetAll(bucketName)
+		return err
+	})
+	return result, err
+}
+
+func (s *SQLite) Delete(key, bucketName string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.Delete(key, bucketName)
+	})
+}
+
+func (s *SQLite) BatchDelete(keys []string, bucketName string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.BatchDelete(keys, bucketName)
+	})
+}
+
+func (s *SQLite) SetJson(key, bucketName string, value interface{}) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetJson(key, bucketName, value)
+	})
+}
+
+func (s *SQLite) GetJson(key, bucketName string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.GetJson(key, bucketName, ret)
+	})
+}
+
+func (s *SQLite) GetJsonList(bucketName string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.GetJsonList(bucketName, ret)
+	})
+}
+
+func (s *SQLite) Version(key, bucketName string) (uint64, error) {
+	var version uint64
+	err := s.View(func(tx *Tx) error {
+		var err error
+		version, err = tx.Version(key, bucketName)
+		return err
+	})
+	return version, err
+}
+
+func (s *SQLite) SetIfVersion(key, bucketName string, value []byte, version uint64) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetIfVersion(key, bucketName, value, version)
+	})
+}
+
+func (s *SQLite) SetJsonIfVersion(key, bucketName string, value interface{}, version uint64) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetJsonIfVersion(key, bucketName, value, version)
+	})
+}
+
+func (s *SQLite) Scan(bucketName string, opts ScanOptions, fn func(item Item) error) (string, error) {
+	var next string
+	err := s.View(func(tx *Tx) error {
+		var err error
+		next, err = tx.Scan(bucketName, opts, fn)
+		return err
+	})
+	return next, err
+}
+
+func (s *SQLite) GetPrefix(bucketName, prefix string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := s.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetPrefix(bucketName, prefix)
+		return err
+	})
+	return result, err
+}
+
+func (s *SQLite) GetRange(bucketName, from, to string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := s.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.GetRange(bucketName, from, to)
+		return err
+	})
+	return result, err
+}
+
+func (s *SQLite) GetJsonPrefix(bucketName, prefix string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.GetJsonPrefix(bucketName, prefix, ret)
+	})
+}
+
+func (s *SQLite) GetJsonRange(bucketName, from, to string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.GetJsonRange(bucketName, from, to, ret)
+	})
+}
+
+func (s *SQLite) FindByIndex(bucketName, indexName, value string) ([]KeyVal, error) {
+	var result []KeyVal
+	err := s.View(func(tx *Tx) error {
+		var err error
+		result, err = tx.FindByIndex(bucketName, indexName, value)
+		return err
+	})
+	return result, err
+}
+
+func (s *SQLite) FindJsonByIndex(bucketName, indexName, value string, ret interface{}) error {
+	return s.View(func(tx *Tx) error {
+		return tx.FindJsonByIndex(bucketName, indexName, value, ret)
+	})
+}
+
+func (s *SQLite) RebuildIndex(bucketName, indexName string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.RebuildIndex(bucketName, indexName)
+	})
+}
+
+func (s *SQLite) Backup(w io.Writer) (int64, error) {
+	return copyBackup(s, w)
+}
+
+func (s *SQLite) Stats() (Stats, error) {
+	return keyStats(s, SQLiteBackend, s.path)
+}
+
+func (s *SQLite) SetWithTTL(key, bucketName string, value []byte, ttl time.Duration) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetWithTTL(key, bucketName, value, ttl)
+	})
+}
+
+func (s *SQLite) SetJsonWithTTL(key, bucketName string, value interface{}, ttl time.Duration) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.SetJsonWithTTL(key, bucketName, value, ttl)
+	})
+}
+
+func (s *SQLite) TTL(key, bucketName string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.View(func(tx *Tx) error {
+		var err error
+		ttl, err = tx.TTL(key, bucketName)
+		return err
+	})
+	return ttl, err
+}
+
+func (s *SQLite) Watch(ctx context.Context, bucketName string, after uint64) (<-chan Change, error) {
+	return watch(ctx, s, &s.changes, bucketName, after)
+}
+
+// Batch is Update, there is no batching of concurrent writes
+func (s *SQLite) Batch(fn func(tx *Tx) error) error {
+	return s.Update(fn)
+}
+
+func (s *SQLite) BatchSet(data []KeyVal, bucketName string) (int, error) {
+	return writeChunks(s, data, int(batchChunk.Int64()), func(tx *Tx, chunk []KeyVal) error {
+		return tx.BatchSet(chunk, bucketName)
+	})
+}
+
+func (s *SQLite) BatchSetJson(data []JsonKeyVal, bucketName string) (int, error) {
+	return writeChunks(s, data, int(batchChunk.Int64()), func(tx *Tx, chunk []JsonKeyVal) error {
+		return tx.BatchSetJson(chunk, bucketName)
+	})
+}
+
+// quoteIdent quote bucket name to be used as table name
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// sqliteTx implement transaction primitives over a sql transaction
+type sqliteTx struct {
+	tx *sql.Tx
+}
+
+func (t sqliteTx) buckets() ([]string, error) {
+	rows, err := t.tx.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, name)
+	}
+	return buckets, rows.Err()
+}
+
+func (t sqliteTx) createBucket(bucketName string) error {
+	_, err := t.tx.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (key TEXT NOT NULL PRIMARY KEY, value BLOB)",
+		quoteIdent(bucketName)))
+	return err
+}
+
+func (t sqliteTx) deleteBucket(bucketName string) error {
+	_, err := t.tx.Exec(fmt.Sprintf("DROP TABLE %s", quoteIdent(bucketName)))
+	return err
+}
+
+func (t sqliteTx) bucketExist(bucketName string) (bool, error) {
+	var n int
+	err := t.tx.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", bucketName).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (t sqliteTx) get(key, bucketName string) ([]byte, error) {
+	var v []byte
+	err := t.tx.QueryRow(fmt.Sprintf("SELECT value FROM %s WHERE key = ?", quoteIdent(bucketName)), key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// empty values are stored as null
+	if v == nil {
+		v = []byte{}
+	}
+	return v, nil
+}
+
+func (t sqliteTx) put(key, bucketName string, value []byte) error {
+	_, err := t.tx.Exec(fmt.Sprintf(
+		"INSERT INTO %s (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value",
+		quoteIdent(bucketName)), key, value)
+	return err
+}
+
+func (t sqliteTx) delete(key, bucketName string) error {
+	_, err := t.tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE key = ?", quoteIdent(bucketName)), key)
+	return err
+}
+
+func (t sqliteTx) cursor(bucketName string) (Cursor, error) {
+	return &sqliteCursor{tx: t.tx, table: quoteIdent(bucketName)}, nil
+}
+
+// sqliteCursorPage is number of rows sqliteCursor load in each query
+const sqliteCursorPage = 100
+
+// sqliteCursor load rows page by page in the direction it is moving
+type sqliteCursor struct {
+	tx      *sql.Tx
+	table   string
+	rows    []KeyVal
+	pos     int
+	reverse bool
+	err     error
+}
+
+// load fill the page with rows matching cond, ordered in cursor direction
+func (c *sqliteCursor) load(reverse bool, cond string, args ...interface{}) ([]byte, []byte) {
+	order := "ASC"
+	if reverse {
+		order = "DESC"
+	}
+	query := fmt.Sprintf("SELECT key, value FROM %s %s ORDER BY key %s LIMIT %d", c.table, cond, order, sqliteCursorPage)
+
+	c.rows, c.pos, c.reverse = nil, 0, reverse
+	rows, err := c.tx.Query(query, args...)
+	if err != nil {
+		c.err = err
+		return nil, nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kv KeyVal
+		if err := rows.Scan(&kv.Key, &kv.Val); err != nil {
+			c.err = err
+			return nil, nil
+		}
+		if kv.Val == nil {
+			kv.Val = []byte{}
+		}
+		c.rows = append(c.rows, kv)
+	}
+	if err := rows.Err(); err != nil {
+		c.err = err
+	}
+	return c.item()
+}
+
+// Err return the error cursor hit while loading rows
+func (c *sqliteCursor) Err() error {
+	return c.err
+}
+
+func (c *sqliteCursor) item() ([]byte, []byte) {
+	if c.pos < 0 || c.pos >= len(c.rows) {
+		return nil, nil
+	}
+	return []byte(c.rows[c.pos].Key), c.rows[c.pos].Val
+}
+
+func (c *sqliteCursor) First() ([]byte, []byte) {
+	return c.load(false, "")
+}
+
+func (c *sqliteCursor) Last() ([]byte, []byte) {
+	return c.load(true, "")
+}
+
+func (c *sqliteCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.load(false, "WHERE key >= ?", string(seek))
+}
+
+func (c *sqliteCursor) move(reverse bool) ([]byte, []byte) {
+	if c.pos < 0 || c.pos >= len(c.rows) {
+		return nil, nil
+	}
+	if c.reverse == reverse {
+		if c.pos+1 < len(c.rows) {
+			c.pos++
+			return c.item()
+		}
+		if len(c.rows) < sqliteCursorPage {
+			// last page is loaded, nothing left in this direction
+			c.pos = len(c.rows)
+			return nil, nil
+		}
+	}
+	current := c.rows[c.pos].Key
+	if reverse {
+		return c.load(true, "WHERE key < ?", current)
+	}
+	return c.load(false, "WHERE key > ?", current)
+}
+
+func (c *sqliteCursor) Next() ([]byte, []byte) {
+	return c.move(false)
+}
+
+func (c *sqliteCursor) Prev() ([]byte, []byte) {
+	return c.move(true)
+}
+
+func init() {
+	Register(SQLiteBackend, func(path string) (Store, error) {
+		s, err := NewSQLite(path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
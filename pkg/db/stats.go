@@ -0,0 +1,170 @@
+package db
+
+import (
+	"expvar"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+	"go.etcd.io/bbolt"
+)
+
+// Stats describe the state of a store, page and transaction
+// fields are reported by the bolt backend only
+type Stats struct {
+	Backend string `json:"backend"`
+	// FileSize is the size of database file in bytes, zero for memory
+	FileSize int64 `json:"file_size"`
+	PageSize int   `json:"page_size"`
+
+	// FreePages is the number of free pages, a big number of them
+	// mean the file can be shrunk by compaction
+	FreePages     int `json:"free_pages"`
+	PendingPages  int `json:"pending_pages"`
+	FreeAlloc     int `json:"free_alloc"`
+	FreelistInuse int `json:"freelist_inuse"`
+
+	// OpenTx is the number of open read transactions, long running
+	// ones keep freed pages from being reused and grow the file
+	OpenTx int `json:"open_tx"`
+	// TxCount is the number of read transactions started since open
+	TxCount int `json:"tx_count"`
+
+	// Buckets include internal buckets, ordered by name
+	Buckets []BucketStats `json:"buckets"`
+}
+
+// BucketStats describe a bucket, page fields are reported by the bolt backend only
+type BucketStats struct {
+	Name string `json:"name"`
+	// Keys include expired records which are not swept yet
+	Keys  int `json:"keys"`
+	Depth int `json:"depth"`
+
+	BranchPages    int `json:"branch_pages"`
+	BranchOverflow int `json:"branch_overflow"`
+	LeafPages      int `json:"leaf_pages"`
+	LeafOverflow   int `json:"leaf_overflow"`
+
+	// allocated and used bytes of pages
+	BranchAlloc int `json:"branch_alloc"`
+	BranchInuse int `json:"branch_inuse"`
+	LeafAlloc   int `json:"leaf_alloc"`
+	LeafInuse   int `json:"leaf_inuse"`
+}
+
+func (s *Service) Stats() (Stats, error) {
+	dbStats := s.DB.Stats()
+	stats := Stats{
+		Backend:       BoltBackend,
+		PageSize:      s.DB.Info().PageSize,
+		FreePages:     dbStats.FreePageN,
+		PendingPages:  dbStats.PendingPageN,
+		FreeAlloc:     dbStats.FreeAlloc,
+		FreelistInuse: dbStats.FreelistInuse,
+		OpenTx:        dbStats.OpenTxN,
+		TxCount:       dbStats.TxN,
+	}
+	info, err := os.Stat(s.DB.Path())
+	if err != nil {
+		return stats, err
+	}
+	stats.FileSize = info.Size()
+	err = s.DB.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			bs := b.Stats()
+			stats.Buckets = append(stats.Buckets, BucketStats{
+				Name:           string(name),
+				Keys:           bs.KeyN,
+				Depth:          bs.Depth,
+				BranchPages:    bs.BranchPageN,
+				BranchOverflow: bs.BranchOverflowN,
+				LeafPages:      bs.LeafPageN,
+				LeafOverflow:   bs.LeafOverflowN,
+				BranchAlloc:    bs.BranchAlloc,
+				BranchInuse:    bs.BranchInuse,
+				LeafAlloc:      bs.LeafAlloc,
+				LeafInuse:      bs.LeafInuse,
+			})
+			return nil
+		})
+	})
+	return stats, err
+}
+
+// keyStats return stats of backends which only report key counts,
+// file size is read from path if it is a file
+func keyStats(s Store, backend, path string) (Stats, error) {
+	stats := Stats{Backend: backend}
+	if path != "" {
+		info, err := os.Stat(path)
+		if err != nil && !os.IsNotExist(err) {
+			return stats, err
+		}
+		if err == nil {
+			stats.FileSize = info.Size()
+		}
+	}
+	err := s.View(func(tx *Tx) error {
+		buckets, err := tx.tx.buckets()
+		if err != nil {
+			return err
+		}
+		sort.Strings(buckets)
+		for _, bucketName := range buckets {
+			c, err := tx.tx.cursor(bucketName)
+			if err != nil {
+				return err
+			}
+			bs := BucketStats{Name: bucketName}
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				bs.Keys++
+			}
+			if err := cursorErr(c); err != nil {
+				return err
+			}
+			stats.Buckets = append(stats.Buckets, bs)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+var (
+	statsMu    sync.RWMutex
+	statsStore Store
+	statsOnce  sync.Once
+)
+
+// PublishStats expose stats of s as the `db` expvar, it replace the store
+// published before. expvar values are served by expvar.Handler
+func PublishStats(s Store) {
+	statsMu.Lock()
+	statsStore = s
+	statsMu.Unlock()
+
+	statsOnce.Do(func() {
+		expvar.Publish("db", expvar.Func(func() interface{} {
+			statsMu.RLock()
+			s := statsStore
+			statsMu.RUnlock()
+
+			stats, err := s.Stats()
+			if err != nil {
+				return map[string]string{"error": err.Error()}
+			}
+			return stats
+		}))
+	})
+}
+
+func init() {
+	registry.Register(func(ctx *projectx.Ctx) error {
+		if i, ok := ctx.Get(ContextKey); ok {
+			PublishStats(i.(Store))
+		}
+		return nil
+	}, 2, false)
+}
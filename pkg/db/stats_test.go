@@ -0,0 +1,79 @@
+package db
+
+import (
+	"encoding/json"
+	"expvar"
+	"path/filepath"
+	"testing"
+)
+
+func bucketStats(stats Stats, name string) (BucketStats, bool) {
+	for _, bs := range stats.Buckets {
+		if bs.Name == name {
+			return bs, true
+		}
+	}
+	return BucketStats{}, false
+}
+
+func testStoreStats(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "a")
+	mustCreateBucket(t, s, "b")
+	mustSet(t, s, "a", "1", "1")
+	mustSet(t, s, "a", "2", "2")
+
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, ok := bucketStats(stats, "a"); !ok || bs.Keys != 2 {
+		t.Errorf("expected 2 keys in bucket a but got %+v", bs)
+	}
+	if bs, ok := bucketStats(stats, "b"); !ok || bs.Keys != 0 {
+		t.Errorf("expected empty bucket b but got %+v", bs)
+	}
+	// record versions are kept in an internal bucket
+	if bs, ok := bucketStats(stats, versionBucket("a")); !ok || bs.Keys != 2 {
+		t.Errorf("expected internal buckets in stats but got %+v", stats.Buckets)
+	}
+}
+
+func TestServiceStats(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mustCreateBucket(t, s, "a")
+	mustSet(t, s, "a", "1", "1")
+
+	err = s.View(func(tx *Tx) error {
+		stats, err := s.Stats()
+		if err != nil {
+			return err
+		}
+		if stats.Backend != BoltBackend || stats.FileSize == 0 || stats.PageSize == 0 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+		// the outer View is still open
+		if stats.OpenTx < 1 {
+			t.Errorf("expected open read tx but got %d", stats.OpenTx)
+		}
+		if bs, ok := bucketStats(stats, "a"); !ok || bs.Keys != 1 || bs.Depth != 1 {
+			t.Errorf("unexpected bucket stats %+v", bs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	PublishStats(s)
+	var published Stats
+	if err := json.Unmarshal([]byte(expvar.Get("db").String()), &published); err != nil {
+		t.Fatal(err)
+	}
+	if published.Backend != BoltBackend {
+		t.Errorf("unexpected published stats %+v", published)
+	}
+}
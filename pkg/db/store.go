@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+)
+
+// Store is the storage api repositories are written against,
+// every backend (bbolt, ...) should implement it
+type Store interface {
+	Close() error
+	// Update run fn in a read-write transaction, changes are
+	// committed if fn return nil and rolled back otherwise
+	Update(fn func(tx *Tx) error) error
+	// Batch run fn like Update, writes of concurrent calls may be committed
+	// in one transaction. fn may run more than once so it should be idempotent
+	Batch(fn func(tx *Tx) error) error
+	// View run fn in a read-only transaction
+	View(fn func(tx *Tx) error) error
+	Buckets() ([]string, error)
+	CreateBucket(bucketName string) error
+	DeleteBucket(bucketName string) error
+	IsExist(key, bucketName string) (bool, error)
+	Set(key, bucketName string, value []byte) error
+	GetOne(key, bucketName string) ([]byte, error)
+	GetAll(bucketName string) ([]KeyVal, error)
+	Delete(key, bucketName string) error
+	BatchDelete(keys []string, bucketName string) error
+	// BatchSet write records in transactions of `db.batch.chunk` records,
+	// it return the number of committed records
+	BatchSet(data []KeyVal, bucketName string) (int, error)
+	BatchSetJson(data []JsonKeyVal, bucketName string) (int, error)
+	SetJson(key, bucketName string, value interface{}) error
+	GetJson(key, bucketName string, ret interface{}) error
+	GetJsonList(bucketName string, ret interface{}) error
+	GetPrefix(bucketName, prefix string) ([]KeyVal, error)
+	GetRange(bucketName, from, to string) ([]KeyVal, error)
+	GetJsonPrefix(bucketName, prefix string, ret interface{}) error
+	GetJsonRange(bucketName, from, to string, ret interface{}) error
+	FindByIndex(bucketName, indexName, value string) ([]KeyVal, error)
+	FindJsonByIndex(bucketName, indexName, value string, ret interface{}) error
+	RebuildIndex(bucketName, indexName string) error
+	// Scan visit bucket records in a read-only transaction, see Tx.Scan
+	Scan(bucketName string, opts ScanOptions, fn func(item Item) error) (string, error)
+	Version(key, bucketName string) (uint64, error)
+	SetIfVersion(key, bucketName string, value []byte, version uint64) error
+	SetJsonIfVersion(key, bucketName string, value interface{}, version uint64) error
+	// SetWithTTL set a record which expire after ttl, see Tx.SetWithTTL
+	SetWithTTL(key, bucketName string, value []byte, ttl time.Duration) error
+	SetJsonWithTTL(key, bucketName string, value interface{}, ttl time.Duration) error
+	TTL(key, bucketName string) (time.Duration, error)
+	// Watch send changes of a bucket registered with RegisterChangeLog
+	// which have seq greater than after, until ctx is done. it fail with
+	// ErrChangesTrimmed if they are trimmed or the bucket is deleted meanwhile,
+	// see Change and OpGap
+	Watch(ctx context.Context, bucketName string, after uint64) (<-chan Change, error)
+	// Backup write a consistent snapshot of the store to w as a bbolt file
+	Backup(w io.Writer) (int64, error)
+	// Stats return state of the store, like file size and key count of buckets
+	Stats() (Stats, error)
+	// ReadOnly return true if writes are rejected with ErrReadOnly
+	ReadOnly() bool
+	// SetReadOnly switch maintenance read-only mode
+	SetReadOnly(readOnly bool) error
+}
+
+// Opener open a store of a backend in the given path
+type Opener func(path string) (Store, error)
+
+var (
+	backendName = config.RegisterString("db.backend", BoltBackend)
+	dbPath      = config.RegisterString("db.path", "data.db")
+)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Opener)
+)
+
+// Register make a backend available by the provided name,
+// it will panic if opener is nil or name is registered twice
+func Register(name string, opener Opener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if opener == nil {
+		panic("db: register opener is nil")
+	}
+	if _, dup := backends[name]; dup {
+		panic("db: register called twice for backend " + name)
+	}
+	backends[name] = opener
+}
+
+// Backends return a sorted list of registered backend names
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	var list []string
+	for name := range backends {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Open open a store using the backend registered as name
+func Open(name, path string) (Store, error) {
+	backendsMu.RLock()
+	opener, ok := backends[name]
+	backendsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown db backend `%s`", name)
+	}
+	return opener(path)
+}
+
+// Copy copy all buckets and keys of src into dst in a single
+// transaction, existing keys of dst will be overwritten
+func Copy(dst, src Store) error {
+	return src.View(func(stx *Tx) error {
+		return dst.Update(func(dtx *Tx) error {
+			return copyTx(dtx, stx)
+		})
+	})
+}
+
+func copyTx(dtx, stx *Tx) error {
+	// copy raw data including internal buckets, so
+	// record versions are kept as they are
+	buckets, err := stx.tx.buckets()
+	if err != nil {
+		return err
+	}
+	for _, bucketName := range buckets {
+		if err := dtx.tx.createBucket(bucketName); err != nil {
+			return err
+		}
+		c, err := stx.tx.cursor(bucketName)
+		if err != nil {
+			return err
+		}
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if err := dtx.tx.put(string(k), bucketName, v); err != nil {
+				return err
+			}
+		}
+		if err := cursorErr(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenConfig open the store configured by `db.backend` and `db.path`,
+// encryption keys of `db.encryption` are loaded too
+func OpenConfig() (Store, error) {
+	if err := loadEncryptionConfig(); err != nil {
+		return nil, err
+	}
+	store, err := Open(backendName.String(), dbPath.String())
+	if err != nil {
+		return nil, fmt.Errorf("open db %s with backend %s failed: %s", dbPath.String(), backendName.String(), err)
+	}
+	return store, nil
+}
+
+// OpenConfigReadOnly open the configured store like OpenConfig but reject
+// writes, a bolt file is opened with a shared lock so it does not need
+// write access. the lock can not be taken while a server owns the file,
+// ErrLockTimeout is returned after `db.bolt.timeout`
+func OpenConfigReadOnly() (Store, error) {
+	if backendName.String() != BoltBackend {
+		store, err := OpenConfig()
+		if err != nil {
+			return nil, err
+		}
+		if err := store.SetReadOnly(true); err != nil {
+			_ = store.Close()
+			return nil, err
+		}
+		return store, nil
+	}
+
+	if err := loadEncryptionConfig(); err != nil {
+		return nil, err
+	}
+	opts, err := ConfigOptions()
+	if err != nil {
+		return nil, err
+	}
+	store, err := New(dbPath.String(), append(opts, WithReadOnly(true))...)
+	if err != nil {
+		return nil, fmt.Errorf("open db %s read-only failed: %s", dbPath.String(), err)
+	}
+	return store, nil
+}
+
+func init() {
+	// open the configured store and share it through project context
+	registry.Register(func(ctx *projectx.Ctx) error {
+		store, err := OpenConfig()
+		if err != nil {
+			return err
+		}
+		ctx.Set(ContextKey, store)
+		return nil
+	}, 0, true)
+}
@@ -0,0 +1,1063 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStore is the conformance suite every backend must pass
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Store)
+	}{
+		{"Buckets", testStoreBuckets},
+		{"CreateBucket", testStoreCreateBucket},
+		{"DeleteBucket", testStoreDeleteBucket},
+		{"SetGetOne", testStoreSetGetOne},
+		{"IsExist", testStoreIsExist},
+		{"GetAllOrdered", testStoreGetAllOrdered},
+		{"Delete", testStoreDelete},
+		{"BatchDelete", testStoreBatchDelete},
+		{"BucketNotExist", testStoreBucketNotExist},
+		{"Errors", testStoreErrors},
+		{"Json", testStoreJson},
+		{"Isolation", testStoreIsolation},
+		{"TxCommit", testStoreTxCommit},
+		{"TxRollback", testStoreTxRollback},
+		{"TxReadOnly", testStoreTxReadOnly},
+		{"TxBucket", testStoreTxBucket},
+		{"Cursor", testStoreCursor},
+		{"Version", testStoreVersion},
+		{"Scan", testStoreScan},
+		{"PrefixRange", testStorePrefixRange},
+		{"Index", testStoreIndex},
+		{"Unique", testStoreUnique},
+		{"Backup", testStoreBackup},
+		{"TTL", testStoreTTL},
+		{"Watch", testStoreWatch},
+		{"Stats", testStoreStats},
+		{"ReadOnly", testStoreReadOnly},
+		{"Batch", testStoreBatch},
+		{"ReservedBytes", testStoreReservedBytes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			defer s.Close()
+			tt.fn(t, s)
+		})
+	}
+}
+
+func testStoreBuckets(t *testing.T, s Store) {
+	buckets, err := s.Buckets()
+	if err != nil || len(buckets) != 0 {
+		t.Fatalf("new store should have no bucket, %v, %v", buckets, err)
+	}
+	mustCreateBucket(t, s, "b")
+	mustCreateBucket(t, s, "a")
+
+	buckets, err = s.Buckets()
+	if err != nil {
+		t.Fatalf("list buckets failed %s", err)
+	}
+	if !reflect.DeepEqual(buckets, []string{"a", "b"}) {
+		t.Errorf("unexpected buckets %v", buckets)
+	}
+}
+
+func testStoreCreateBucket(t *testing.T, s Store) {
+	if err := s.CreateBucket("test"); err != nil {
+		t.Fatalf("create bucket failed %s", err)
+	}
+	// creating an existing bucket is not an error
+	if err := s.CreateBucket("test"); err != nil {
+		t.Fatalf("create existing bucket failed %s", err)
+	}
+	if err := s.CreateBucket(""); err == nil {
+		t.Error("create bucket should fail with empty name")
+	}
+}
+
+func testStoreDeleteBucket(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "k", "v")
+
+	if err := s.DeleteBucket("test"); err != nil {
+		t.Fatalf("delete bucket failed %s", err)
+	}
+	if err := s.DeleteBucket("test"); err == nil {
+		t.Error("delete bucket should fail when not exist")
+	}
+
+	// recreated bucket must be empty
+	mustCreateBucket(t, s, "test")
+	all, err := s.GetAll("test")
+	if err != nil {
+		t.Fatalf("get all failed %s", err)
+	}
+	if len(all) != 0 {
+		t.Errorf("recreated bucket is not empty: %v", all)
+	}
+}
+
+func testStoreSetGetOne(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	val := []byte("this is a test")
+	if err := s.Set("key", "test", val); err != nil {
+		t.Fatalf("set failed %s", err)
+	}
+	got, err := s.GetOne("key", "test")
+	if err != nil {
+		t.Fatalf("get one failed %s", err)
+	}
+	if !bytes.Equal(got, val) {
+		t.Errorf("read value is not same as wrote, %q", got)
+	}
+
+	// overwrite
+	mustSet(t, s, "test", "key", "new value")
+	got, _ = s.GetOne("key", "test")
+	if string(got) != "new value" {
+		t.Errorf("value is not overwritten, %q", got)
+	}
+
+	// empty value is a valid value
+	mustSet(t, s, "test", "empty", "")
+	got, err = s.GetOne("empty", "test")
+	if err != nil || len(got) != 0 {
+		t.Errorf("empty value is not stored, %q, %v", got, err)
+	}
+
+	if _, err := s.GetOne("not-exist", "test"); err == nil {
+		t.Error("get one should fail when key not exist")
+	}
+	if err := s.Set("", "test", val); err == nil {
+		t.Error("set should fail with empty key")
+	}
+}
+
+func testStoreIsExist(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "key", "val")
+
+	ok, err := s.IsExist("key", "test")
+	if err != nil || !ok {
+		t.Errorf("key should exist, %v", err)
+	}
+	ok, err = s.IsExist("not-exist", "test")
+	if err != nil || ok {
+		t.Errorf("key should not exist, %v", err)
+	}
+}
+
+func testStoreGetAllOrdered(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	for _, k := range []string{"b", "c", "a", "aa", "B"} {
+		mustSet(t, s, "test", k, "val-"+k)
+	}
+
+	all, err := s.GetAll("test")
+	if err != nil {
+		t.Fatalf("get all failed %s", err)
+	}
+	var keys []string
+	for _, kv := range all {
+		keys = append(keys, kv.Key)
+		if string(kv.Val) != "val-"+kv.Key {
+			t.Errorf("key %s has wrong value %q", kv.Key, kv.Val)
+		}
+	}
+	expected := []string{"B", "a", "aa", "b", "c"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("keys are not ordered, %v != %v", keys, expected)
+	}
+}
+
+func testStoreDelete(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "key", "val")
+
+	if err := s.Delete("key", "test"); err != nil {
+		t.Fatalf("delete failed %s", err)
+	}
+	if ok, _ := s.IsExist("key", "test"); ok {
+		t.Error("deleted key still exist")
+	}
+	// deleting a missing key is not an error
+	if err := s.Delete("key", "test"); err != nil {
+		t.Errorf("delete missing key failed %s", err)
+	}
+}
+
+func testStoreBatchDelete(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	for _, k := range []string{"a", "b", "c"} {
+		mustSet(t, s, "test", k, k)
+	}
+
+	if err := s.BatchDelete([]string{"a", "c", "not-exist"}, "test"); err != nil {
+		t.Fatalf("batch delete failed %s", err)
+	}
+	all, _ := s.GetAll("test")
+	if len(all) != 1 || all[0].Key != "b" {
+		t.Errorf("unexpected keys after batch delete, %v", all)
+	}
+}
+
+func testStoreBucketNotExist(t *testing.T, s Store) {
+	var ret []string
+	checks := map[string]error{
+		"Set":         s.Set("k", "not-exist", []byte("v")),
+		"Delete":      s.Delete("k", "not-exist"),
+		"BatchDelete": s.BatchDelete([]string{"k"}, "not-exist"),
+		"SetJson":     s.SetJson("k", "not-exist", "v"),
+		"GetJsonList": s.GetJsonList("not-exist", &ret),
+	}
+	_, checks["GetOne"] = s.GetOne("k", "not-exist")
+	_, checks["GetAll"] = s.GetAll("not-exist")
+	_, checks["IsExist"] = s.IsExist("k", "not-exist")
+	checks["DeleteBucket"] = s.DeleteBucket("not-exist")
+
+	for name, err := range checks {
+		if !errors.Is(err, ErrBucketNotFound) {
+			t.Errorf("%s should fail with ErrBucketNotFound when bucket not exist, got %v", name, err)
+		}
+	}
+}
+
+func testStoreErrors(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "a", "1")
+
+	var ret string
+	_, err := s.GetOne("not-exist", "test")
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("GetOne should fail with ErrNotFound, got %v", err)
+	}
+	if err := s.GetJson("not-exist", "test", &ret); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetJson should fail with ErrNotFound, got %v", err)
+	}
+
+	err = s.SetIfVersion("a", "test", []byte("2"), 5)
+	var conflict *ConflictError
+	if !errors.Is(err, ErrConflict) || !errors.As(fmt.Errorf("wrapped: %w", err), &conflict) {
+		t.Fatalf("SetIfVersion should fail with ErrConflict, got %v", err)
+	}
+	if conflict.Key != "a" || conflict.Expected != 5 || conflict.Actual != 1 {
+		t.Errorf("unexpected conflict %+v", conflict)
+	}
+}
+
+func testStoreJson(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	type User struct {
+		Username string
+		Email    string
+	}
+	users := []User{
+		{Username: "test1", Email: "test1@test.com"},
+		{Username: "test2", Email: "test2@test.com"},
+	}
+	for _, u := range users {
+		if err := s.SetJson(u.Username, "test", u); err != nil {
+			t.Fatalf("set json failed %s", err)
+		}
+	}
+
+	var u User
+	if err := s.GetJson("test2", "test", &u); err != nil {
+		t.Fatalf("get json failed %s", err)
+	}
+	if u != users[1] {
+		t.Errorf("get json returned wrong data, %v", u)
+	}
+
+	var list []User
+	if err := s.GetJsonList("test", &list); err != nil {
+		t.Fatalf("get json list failed %s", err)
+	}
+	if !reflect.DeepEqual(list, users) {
+		t.Errorf("returned json list is not same as input, %v", list)
+	}
+}
+
+// testStoreIsolation make sure callers can not change stored data
+// by mutating slices passed to or returned from the store
+func testStoreIsolation(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	val := []byte("value")
+	if err := s.Set("key", "test", val); err != nil {
+		t.Fatalf("set failed %s", err)
+	}
+	val[0] = 'X'
+
+	got, _ := s.GetOne("key", "test")
+	if string(got) != "value" {
+		t.Errorf("stored value changed by caller, %q", got)
+	}
+}
+
+func testStoreTxBucket(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	err := s.Update(func(tx *Tx) error {
+		b := tx.Bucket("test")
+		if err := b.Set("a", []byte("a")); err != nil {
+			return err
+		}
+		if err := b.SetJson("b", map[string]int{"n": 1}); err != nil {
+			return err
+		}
+		var ret map[string]int
+		if err := b.GetJson("b", &ret); err != nil || ret["n"] != 1 {
+			t.Errorf("expected n=1 but got %v, %v", ret, err)
+		}
+		if err := b.Delete("a"); err != nil {
+			return err
+		}
+		if ok, _ := b.IsExist("a"); ok {
+			t.Error("key a should be deleted")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.View(func(tx *Tx) error {
+		all, err := tx.Bucket("test").GetAll()
+		if err != nil || len(all) != 1 || all[0].Key != "b" {
+			t.Errorf("expected only key b but got %v, %v", all, err)
+		}
+		if _, err := tx.Bucket("not-exist").Get("b"); !errors.Is(err, ErrBucketNotFound) {
+			t.Errorf("expected ErrBucketNotFound but got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreTxCommit(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "old", "old")
+
+	err := s.Update(func(tx *Tx) error {
+		if err := tx.CreateBucket("other"); err != nil {
+			return err
+		}
+		if err := tx.Set("a", "test", []byte("a")); err != nil {
+			return err
+		}
+		if err := tx.Set("b", "other", []byte("b")); err != nil {
+			return err
+		}
+		// changes are visible inside the transaction
+		v, err := tx.GetOne("a", "test")
+		if err != nil || string(v) != "a" {
+			t.Errorf("write is not visible in transaction, %q, %v", v, err)
+		}
+		return tx.Delete("old", "test")
+	})
+	if err != nil {
+		t.Fatalf("update failed %s", err)
+	}
+
+	if v, err := s.GetOne("a", "test"); err != nil || string(v) != "a" {
+		t.Errorf("key a is not committed, %q, %v", v, err)
+	}
+	if v, err := s.GetOne("b", "other"); err != nil || string(v) != "b" {
+		t.Errorf("key b is not committed, %q, %v", v, err)
+	}
+	if ok, _ := s.IsExist("old", "test"); ok {
+		t.Error("delete is not committed")
+	}
+}
+
+func testStoreTxRollback(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	mustSet(t, s, "test", "a", "a")
+	mustSet(t, s, "test", "b", "b")
+
+	fail := errors.New("fail")
+	err := s.Update(func(tx *Tx) error {
+		_ = tx.Set("a", "test", []byte("changed"))
+		_ = tx.Set("c", "test", []byte("c"))
+		_ = tx.Delete("b", "test")
+		_ = tx.CreateBucket("other")
+		_ = tx.DeleteBucket("test")
+		return fail
+	})
+	if err != fail {
+		t.Fatalf("update should return fn error, %v", err)
+	}
+
+	all, err := s.GetAll("test")
+	if err != nil {
+		t.Fatalf("get all failed %s", err)
+	}
+	expected := []KeyVal{{Key: "a", Val: []byte("a")}, {Key: "b", Val: []byte("b")}}
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("changes are not rolled back, %v", all)
+	}
+	if buckets, _ := s.Buckets(); !reflect.DeepEqual(buckets, []string{"test"}) {
+		t.Errorf("bucket changes are not rolled back, %v", buckets)
+	}
+}
+
+func testStoreTxReadOnly(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	err := s.View(func(tx *Tx) error {
+		if tx.Writable() {
+			t.Error("view transaction should not be writable")
+		}
+		if err := tx.Set("a", "test", []byte("a")); err == nil {
+			t.Error("set should fail in read-only transaction")
+		}
+		if err := tx.Delete("a", "test"); err == nil {
+			t.Error("delete should fail in read-only transaction")
+		}
+		if err := tx.CreateBucket("other"); err == nil {
+			t.Error("create bucket should fail in read-only transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("view failed %s", err)
+	}
+}
+
+func testStoreCursor(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	// more than a sqlite cursor page
+	var keys []string
+	err := s.Update(func(tx *Tx) error {
+		for i := 0; i < 250; i++ {
+			k := fmt.Sprintf("key-%03d", i)
+			keys = append(keys, k)
+			if err := tx.Set(k, "test", []byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update failed %s", err)
+	}
+
+	err = s.View(func(tx *Tx) error {
+		c, err := tx.Cursor("test")
+		if err != nil {
+			return err
+		}
+
+		var got []string
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if string(k) != string(v) {
+				t.Errorf("key %s has wrong value %s", k, v)
+			}
+			got = append(got, string(k))
+		}
+		if !reflect.DeepEqual(got, keys) {
+			t.Errorf("forward iteration returned %d keys", len(got))
+		}
+
+		got = nil
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			got = append(got, string(k))
+		}
+		if len(got) != len(keys) || got[0] != keys[len(keys)-1] || got[len(got)-1] != keys[0] {
+			t.Errorf("backward iteration returned %d keys", len(got))
+		}
+
+		if k, _ := c.Seek([]byte("key-149x")); string(k) != "key-150" {
+			t.Errorf("seek returned %s", k)
+		}
+		if k, _ := c.Prev(); string(k) != "key-149" {
+			t.Errorf("prev after seek returned %s", k)
+		}
+		if k, _ := c.Next(); string(k) != "key-150" {
+			t.Errorf("next after prev returned %s", k)
+		}
+		if k, _ := c.Seek([]byte("z")); k != nil {
+			t.Errorf("seek after last key returned %s", k)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("view failed %s", err)
+	}
+
+	if err := s.View(func(tx *Tx) error {
+		_, err := tx.Cursor("not-exist")
+		return err
+	}); err == nil {
+		t.Error("cursor should fail when bucket not exist")
+	}
+}
+
+func testStoreVersion(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+
+	if v, err := s.Version("key", "test"); err != nil || v != 0 {
+		t.Errorf("missing key should have version 0, %d, %v", v, err)
+	}
+	mustSet(t, s, "test", "key", "v1")
+	mustSet(t, s, "test", "key", "v2")
+	if v, err := s.Version("key", "test"); err != nil || v != 2 {
+		t.Errorf("expected version 2, %d, %v", v, err)
+	}
+
+	if err := s.SetIfVersion("key", "test", []byte("v3"), 2); err != nil {
+		t.Fatalf("set if version failed %s", err)
+	}
+	err := s.SetJsonIfVersion("key", "test", "v4", 2)
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("expected conflict error, %v", err)
+	}
+	if conflict.Expected != 2 || conflict.Actual != 3 || conflict.Key != "key" {
+		t.Errorf("unexpected conflict %+v", conflict)
+	}
+	if v, _ := s.GetOne("key", "test"); string(v) != "v3" {
+		t.Errorf("conflicting write is stored, %q", v)
+	}
+
+	err = s.Update(func(tx *Tx) error {
+		if err := tx.DeleteIfVersion("key", "test", 1); err == nil {
+			t.Error("delete if version should fail on conflict")
+		}
+		return tx.DeleteIfVersion("key", "test", 3)
+	})
+	if err != nil {
+		t.Fatalf("delete if version failed %s", err)
+	}
+	if v, _ := s.Version("key", "test"); v != 0 {
+		t.Errorf("deleted key should have version 0, %d", v)
+	}
+
+	// companion buckets are hidden and dropped with the bucket
+	if buckets, _ := s.Buckets(); !reflect.DeepEqual(buckets, []string{"test"}) {
+		t.Errorf("internal buckets are listed, %v", buckets)
+	}
+	mustSet(t, s, "test", "key", "v1")
+	if err := s.DeleteBucket("test"); err != nil {
+		t.Fatalf("delete bucket failed %s", err)
+	}
+	mustCreateBucket(t, s, "test")
+	if v, _ := s.Version("key", "test"); v != 0 {
+		t.Errorf("recreated bucket should not keep versions, %d", v)
+	}
+	if err := s.CreateBucket(internalPrefix + "test"); err == nil {
+		t.Error("create bucket should fail with reserved name")
+	}
+}
+
+func testStoreScan(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		mustSet(t, s, "test", k, `"`+k+`"`)
+	}
+
+	scan := func(opts ScanOptions) ([]string, string) {
+		var keys []string
+		next, err := s.Scan("test", opts, func(item Item) error {
+			var v string
+			if err := item.Json(&v); err != nil {
+				return err
+			}
+			if v != item.Key {
+				t.Errorf("key %s has wrong value %s", item.Key, v)
+			}
+			keys = append(keys, item.Key)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("scan failed %s", err)
+		}
+		return keys, next
+	}
+
+	tests := []struct {
+		opts ScanOptions
+		keys []string
+		next string
+	}{
+		{ScanOptions{}, []string{"a", "b", "c", "d", "e"}, ""},
+		{ScanOptions{Limit: 2}, []string{"a", "b"}, "b"},
+		{ScanOptions{Limit: 2, After: "b"}, []string{"c", "d"}, "d"},
+		{ScanOptions{Limit: 2, After: "d"}, []string{"e"}, ""},
+		{ScanOptions{Limit: 1, After: "d"}, []string{"e"}, ""},
+		{ScanOptions{After: "bb"}, []string{"c", "d", "e"}, ""},
+		{ScanOptions{After: "z"}, nil, ""},
+		{ScanOptions{Reverse: true, Limit: 2}, []string{"e", "d"}, "d"},
+		{ScanOptions{Reverse: true, Limit: 2, After: "d"}, []string{"c", "b"}, "b"},
+		{ScanOptions{Reverse: true, After: "bb"}, []string{"b", "a"}, ""},
+		{ScanOptions{Reverse: true, After: "z"}, []string{"e", "d", "c", "b", "a"}, ""},
+		{ScanOptions{Reverse: true, After: "a"}, nil, ""},
+	}
+	for _, tt := range tests {
+		keys, next := scan(tt.opts)
+		if !reflect.DeepEqual(keys, tt.keys) || next != tt.next {
+			t.Errorf("scan %+v returned %v, %q expected %v, %q", tt.opts, keys, next, tt.keys, tt.next)
+		}
+	}
+
+	// stop early
+	var keys []string
+	next, err := s.Scan("test", ScanOptions{}, func(item Item) error {
+		keys = append(keys, item.Key)
+		if len(keys) == 3 {
+			return ErrStopScan
+		}
+		return nil
+	})
+	if err != nil || next != "c" || len(keys) != 3 {
+		t.Errorf("stopped scan returned %v, %q, %v", keys, next, err)
+	}
+
+	fail := errors.New("fail")
+	if _, err := s.Scan("test", ScanOptions{}, func(item Item) error { return fail }); err != fail {
+		t.Errorf("scan should return callback error, %v", err)
+	}
+	if _, err := s.Scan("not-exist", ScanOptions{}, func(item Item) error { return nil }); err == nil {
+		t.Error("scan should fail when bucket not exist")
+	}
+}
+
+func testStorePrefixRange(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "test")
+	keys := []string{
+		JoinKey("sprint", "1", "task", "a"),
+		JoinKey("sprint", "1", "task", "b"),
+		JoinKey("sprint", "10", "task", "c"),
+		JoinKey("sprint", "2", "task", "d"),
+		JoinKey("sprint", "2", "task", "e"),
+		"zzz",
+	}
+	for _, k := range keys {
+		if err := s.SetJson(k, "test", k); err != nil {
+			t.Fatalf("set json failed %s", err)
+		}
+	}
+
+	keysOf := func(data []KeyVal) []string {
+		var r []string
+		for _, kv := range data {
+			r = append(r, kv.Key)
+		}
+		return r
+	}
+
+	data, err := s.GetPrefix("test", "sprint/1/")
+	if err != nil {
+		t.Fatalf("get prefix failed %s", err)
+	}
+	if got := keysOf(data); !reflect.DeepEqual(got, keys[:2]) {
+		t.Errorf("prefix returned %v", got)
+	}
+
+	data, err = s.GetRange("test", "sprint/10", "sprint/2/task/e")
+	if err != nil {
+		t.Fatalf("get range failed %s", err)
+	}
+	if got := keysOf(data); !reflect.DeepEqual(got, keys[2:4]) {
+		t.Errorf("range returned %v", got)
+	}
+
+	data, _ = s.GetRange("test", "sprint/2", "")
+	if got := keysOf(data); !reflect.DeepEqual(got, keys[3:]) {
+		t.Errorf("open range returned %v", got)
+	}
+
+	var values []string
+	if err := s.GetJsonPrefix("test", "sprint/2/", &values); err != nil {
+		t.Fatalf("get json prefix failed %s", err)
+	}
+	if !reflect.DeepEqual(values, keys[3:5]) {
+		t.Errorf("json prefix returned %v", values)
+	}
+	if err := s.GetJsonRange("test", "", "sprint/10", &values); err != nil {
+		t.Fatalf("get json range failed %s", err)
+	}
+	if !reflect.DeepEqual(values, keys[:2]) {
+		t.Errorf("json range returned %v", values)
+	}
+
+	// reverse prefix scan with paging
+	var got []string
+	opts := ScanOptions{Prefix: "sprint/", Reverse: true, Limit: 3}
+	next, err := s.Scan("test", opts, func(item Item) error {
+		got = append(got, item.Key)
+		return nil
+	})
+	if err != nil || next != keys[2] || !reflect.DeepEqual(got, []string{keys[4], keys[3], keys[2]}) {
+		t.Errorf("reverse prefix scan returned %v, %q, %v", got, next, err)
+	}
+	opts.After = next
+	got = nil
+	next, _ = s.Scan("test", opts, func(item Item) error {
+		got = append(got, item.Key)
+		return nil
+	})
+	if next != "" || !reflect.DeepEqual(got, []string{keys[1], keys[0]}) {
+		t.Errorf("reverse prefix scan second page returned %v, %q", got, next)
+	}
+}
+
+type indexedUser struct {
+	Name  string
+	Team  string
+	Roles []string
+}
+
+func init() {
+	RegisterIndex("indexed", JsonIndex("team", indexedUser{}, func(r interface{}) []string {
+		return []string{r.(*indexedUser).Team}
+	}))
+	RegisterIndex("indexed", JsonIndex("role", indexedUser{}, func(r interface{}) []string {
+		return r.(*indexedUser).Roles
+	}))
+	RegisterUnique("unique", JsonIndex("email", uniqueUser{}, func(r interface{}) []string {
+		return []string{r.(*uniqueUser).Email}
+	}))
+}
+
+type uniqueUser struct {
+	Name  string
+	Email string
+}
+
+func testStoreUnique(t *testing.T, s Store) {
+	mustCreateBucket(t, s, "unique")
+
+	if err := s.SetJson("u1", "unique", uniqueUser{Name: "u1", Email: "a@test.com"}); err != nil {
+		t.Fatalf("set json failed %s", err)
+	}
+	// rewriting the same record is not a violation
+	if err := s.SetJson("u1", "unique", uniqueUser{Name: "u1-new", Email: "a@test.com"}); err != nil {
+		t.Fatalf("set json failed %s", err)
+	}
+
+	err := s.SetJson("u2", "unique", uniqueUser{Name: "u2", Email: "a@test.com"})
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Fatalf("expected unique violation but got %v", err)
+	}
+	var uniqueErr *UniqueError
+	if !errors.As(err, &uniqueErr) || uniqueErr.Field != "email" || uniqueErr.Key != "u1" {
+		t.Errorf("unexpected unique error %+v", uniqueErr)
+	}
+	if ok, _ := s.IsExist("u2", "unique"); ok {
+		t.Error("violating record is stored")
+	}
+
+	// value is free again after the holder change it
+	err = s.Update(func(tx *Tx) error {
+		if err := tx.SetJson("u1", "unique", uniqueUser{Name: "u1", Email: "b@test.com"}); err != nil {
+			return err
+		}
+		return tx.SetJson("u2", "unique", uniqueUser{Name: "u2", Email: "a@test.com"})
+	})
+	if err != nil {
+		t.Fatalf("update failed %s", err)
+	}
+
+	// violation roll back the whole transaction
+	err = s.Update(func(tx *Tx) error {
+		if err := tx.SetJson("u3", "unique", uniqueUser{Name: "u3", Email: "c@test.com"}); err != nil {
+			return err
+		}
+		return tx.SetJson("u4", "unique", uniqueUser{Name: "u4", Email: "c@test.com"})
+	})
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Fatalf("expected unique violation but got %v", err)
+	}
+	if ok, _ := s.IsExist("u3", "unique"); ok {
+		t.Error("transaction with violation is committed")
+	}
+	if err := s.SetJson("u5", "unique", uniqueUser{Name: "u5", Email: "c@test.com"}); err != nil {
+		t.Errorf("value of rolled back write should be free, %s", err)
+	}
+
+	if err := s.Delete("u2", "unique"); err != nil {
+		t.Fatalf("delete failed %s", err)
+	}
+	if err := s.SetJson("u6", "unique", uniqueUser{Name: "u6", Email: "a@test.com"}); err != nil {
+		t.Errorf("value of deleted record should be free, %s", err)
+	}
+
+	// value of an expired record is free before it is swept
+	advance := setClock(t)
+	if err := s.SetJsonWithTTL("u7", "unique", uniqueUser{Name: "u7", Email: "d@test.com"}, time.Minute); err != nil {
+		t.Fatalf("set json with ttl failed %s", err)
+	}
+	if err := s.SetJson("u8", "unique", uniqueUser{Name: "u8", Email: "d@test.com"}); !errors.Is(err, ErrUniqueViolation) {
+		t.Errorf("value of a live record should not be free, got %v", err)
+	}
+	advance(2 * time.Minute)
+	if err := s.SetJson("u8", "unique", uniqueUser{Name: "u8", Email: "d@test.com"}); err != nil {
+		t.Errorf("value of expired record should be free, %s", err)
+	}
+	err = s.View(func(tx *Tx) error {
+		v, err := tx.tx.get("u7", "unique")
+		if err == nil && v != nil {
+			t.Error("expired holder should be deleted")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreIndex(t *testing.T, s Store) {
+	// records written before the bucket has indexes
+	err := s.Update(func(tx *Tx) error {
+		if err := tx.tx.createBucket("indexed"); err != nil {
+			return err
+		}
+		buf, _ := json.Marshal(indexedUser{Name: "old", Team: "a"})
+		return tx.tx.put("old", "indexed", buf)
+	})
+	if err != nil {
+		t.Fatalf("prepare bucket failed %s", err)
+	}
+	// create bucket build missing indexes
+	mustCreateBucket(t, s, "indexed")
+
+	users := []indexedUser{
+		{Name: "u1", Team: "a", Roles: []string{"admin", "dev"}},
+		{Name: "u2", Team: "b", Roles: []string{"dev"}},
+		{Name: "u3", Team: "a"},
+	}
+	for _, u := range users {
+		if err := s.SetJson(u.Name, "indexed", u); err != nil {
+			t.Fatalf("set json failed %s", err)
+		}
+	}
+
+	find := func(index, value string) []string {
+		var found []indexedUser
+		if err := s.FindJsonByIndex("indexed", index, value, &found); err != nil {
+			t.Fatalf("find by index failed %s", err)
+		}
+		var names []string
+		for _, u := range found {
+			names = append(names, u.Name)
+		}
+		return names
+	}
+
+	if names := find("team", "a"); !reflect.DeepEqual(names, []string{"old", "u1", "u3"}) {
+		t.Errorf("team a has %v", names)
+	}
+	if names := find("role", "dev"); !reflect.DeepEqual(names, []string{"u1", "u2"}) {
+		t.Errorf("role dev has %v", names)
+	}
+
+	// update move record between index values
+	if err := s.SetJson("u1", "indexed", indexedUser{Name: "u1", Team: "b"}); err != nil {
+		t.Fatalf("set json failed %s", err)
+	}
+	if names := find("team", "b"); !reflect.DeepEqual(names, []string{"u1", "u2"}) {
+		t.Errorf("team b has %v", names)
+	}
+	if names := find("role", "admin"); names != nil {
+		t.Errorf("role admin has %v", names)
+	}
+
+	if err := s.Delete("u2", "indexed"); err != nil {
+		t.Fatalf("delete failed %s", err)
+	}
+	if err := s.BatchDelete([]string{"old", "u3"}, "indexed"); err != nil {
+		t.Fatalf("batch delete failed %s", err)
+	}
+	if names := find("team", "a"); names != nil {
+		t.Errorf("team a has %v", names)
+	}
+	if names := find("team", "b"); !reflect.DeepEqual(names, []string{"u1"}) {
+		t.Errorf("team b has %v", names)
+	}
+
+	// a failed transaction does not leave index entries behind
+	_ = s.Update(func(tx *Tx) error {
+		_ = tx.SetJson("u4", "indexed", indexedUser{Name: "u4", Team: "c"})
+		return errors.New("fail")
+	})
+	if names := find("team", "c"); names != nil {
+		t.Errorf("team c has %v", names)
+	}
+
+	if err := s.RebuildIndex("indexed", "team"); err != nil {
+		t.Fatalf("rebuild index failed %s", err)
+	}
+	if names := find("team", "b"); !reflect.DeepEqual(names, []string{"u1"}) {
+		t.Errorf("team b has %v after rebuild", names)
+	}
+
+	if _, err := s.FindByIndex("indexed", "not-exist", "a"); err == nil {
+		t.Error("find should fail with unknown index")
+	}
+	if err := s.RebuildIndex("indexed", "not-exist"); err == nil {
+		t.Error("rebuild should fail with unknown index")
+	}
+	if err := s.Set("bad", "indexed", []byte("not json")); err == nil {
+		t.Error("set should fail when index can not be extracted")
+	}
+
+	// a value with the separator would be found by its prefix
+	if err := s.SetJson("u5", "indexed", indexedUser{Name: "u5", Team: "b\x00u1"}); err == nil {
+		t.Error("set should fail when index value contain NUL")
+	}
+	if names := find("team", "b"); !reflect.DeepEqual(names, []string{"u1"}) {
+		t.Errorf("team b has %v", names)
+	}
+	if _, err := s.FindByIndex("indexed", "team", "b\x00u1"); err == nil {
+		t.Error("find should fail when value contain NUL")
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"a":        "b",
+		"sprint/":  "sprint0",
+		"a\xff":    "b",
+		"\xff\xff": "",
+	}
+	for prefix, end := range tests {
+		if got := prefixEnd(prefix); got != end {
+			t.Errorf("prefix end of %q is %q, expected %q", prefix, got, end)
+		}
+	}
+}
+
+func mustCreateBucket(t *testing.T, s Store, bucket string) {
+	t.Helper()
+	if err := s.CreateBucket(bucket); err != nil {
+		t.Fatalf("create bucket %s failed %s", bucket, err)
+	}
+}
+
+func mustSet(t *testing.T, s Store, bucket, key, val string) {
+	t.Helper()
+	if err := s.Set(key, bucket, []byte(val)); err != nil {
+		t.Fatalf("set %s failed %s", key, err)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		s, err := New(filepath.Join(t.TempDir(), "bolt.db"))
+		if err != nil {
+			t.Fatalf("create db failed %s", err)
+		}
+		return s
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemory()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		s, err := NewSQLite(filepath.Join(t.TempDir(), "sqlite.db"))
+		if err != nil {
+			t.Fatalf("create db failed %s", err)
+		}
+		return s
+	})
+}
+
+func TestOpen(t *testing.T) {
+
+	db := filepath.Join(t.TempDir(), "db_open")
+	store, err := Open(BoltBackend, db)
+	if err != nil {
+		t.Errorf("open db failed %s", err.Error())
+		return
+	}
+	defer store.Close()
+
+	if _, ok := store.(*Service); !ok {
+		t.Errorf("expected bolt service but got %T", store)
+	}
+
+	store, err = Open(MemoryBackend, "")
+	if err != nil {
+		t.Errorf("open memory db failed %s", err.Error())
+		return
+	}
+	if _, ok := store.(*Memory); !ok {
+		t.Errorf("expected memory store but got %T", store)
+	}
+
+	store, err = Open(SQLiteBackend, filepath.Join(t.TempDir(), "db_open.sqlite"))
+	if err != nil {
+		t.Errorf("open sqlite db failed %s", err.Error())
+		return
+	}
+	defer store.Close()
+	if _, ok := store.(*SQLite); !ok {
+		t.Errorf("expected sqlite store but got %T", store)
+	}
+
+	_, err = Open("not-exist", db)
+	if err == nil {
+		t.Error("open should fail with unknown backend")
+	}
+}
+
+func TestBackends(t *testing.T) {
+	expected := []string{BoltBackend, MemoryBackend, SQLiteBackend}
+	if !reflect.DeepEqual(Backends(), expected) {
+		t.Errorf("registered backends are %v, expected %v", Backends(), expected)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src := NewMemory()
+	mustCreateBucket(t, src, "a")
+	mustCreateBucket(t, src, "b")
+	mustSet(t, src, "a", "k1", "v1")
+	mustSet(t, src, "b", "k2", "v2")
+
+	dst, err := NewSQLite(filepath.Join(t.TempDir(), "copy.db"))
+	if err != nil {
+		t.Fatalf("create db failed %s", err)
+	}
+	defer dst.Close()
+
+	if err := Copy(dst, src); err != nil {
+		t.Fatalf("copy failed %s", err)
+	}
+	for _, bucket := range []string{"a", "b"} {
+		want, _ := src.GetAll(bucket)
+		got, err := dst.GetAll(bucket)
+		if err != nil {
+			t.Fatalf("get all failed %s", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("bucket %s is not copied, %v != %v", bucket, got, want)
+		}
+	}
+}
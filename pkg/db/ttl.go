@@ -0,0 +1,296 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+)
+
+// sweepBatch is the maximum number of expired records deleted in a transaction
+const sweepBatch = 1000
+
+var sweepInterval = config.RegisterString("db.ttl.sweep_interval", "1m")
+
+// now is replaced in tests
+var now = time.Now
+
+// ttlBucket is the companion bucket holding deadline of expiring records of bucketName
+func ttlBucket(bucketName string) string {
+	return internalPrefix + "ttl." + bucketName
+}
+
+// expiryBucket index expiring records of bucketName by their deadline,
+// keys are the big-endian deadline in unix nano followed by record key
+func expiryBucket(bucketName string) string {
+	return internalPrefix + "expiry." + bucketName
+}
+
+func expiryEntry(deadline uint64, key string) string {
+	buf := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(buf, deadline)
+	return string(append(buf, key...))
+}
+
+// SetWithTTL set the value like Set, the record expire after ttl. expired
+// records are not visible to reads and are deleted by the sweeper.
+// a later Set on the key make the record permanent again
+func (tx *Tx) SetWithTTL(key, bucketName string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl should be positive")
+	}
+	if err := tx.Set(key, bucketName, value); err != nil {
+		return err
+	}
+	return tx.setDeadline(key, bucketName, uint64(now().Add(ttl).UnixNano()))
+}
+
+// SetJsonWithTTL encode value like SetJson and set it with SetWithTTL
+func (tx *Tx) SetJsonWithTTL(key, bucketName string, value interface{}, ttl time.Duration) error {
+	buf, err := encodeValue(bucketName, value)
+	if err != nil {
+		return err
+	}
+	return tx.SetWithTTL(key, bucketName, buf, ttl)
+}
+
+// TTL return the remaining time to live of a record, zero if it does not expire
+func (tx *Tx) TTL(key, bucketName string) (time.Duration, error) {
+	if err := tx.checkBucket(bucketName); err != nil {
+		return 0, err
+	}
+	deadline, ok, err := tx.deadline(key, bucketName)
+	if err != nil || !ok {
+		return 0, err
+	}
+	if left := time.Duration(int64(deadline) - now().UnixNano()); left > 0 {
+		return left, nil
+	}
+	return 0, keyNotExist(key, bucketName)
+}
+
+func (tx *Tx) deadline(key, bucketName string) (uint64, bool, error) {
+	tb := ttlBucket(bucketName)
+	ok, err := tx.tx.bucketExist(tb)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	v, err := tx.tx.get(key, tb)
+	if err != nil || len(v) != 8 {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(v), true, nil
+}
+
+func (tx *Tx) setDeadline(key, bucketName string, deadline uint64) error {
+	if err := tx.clearDeadline(key, bucketName); err != nil {
+		return err
+	}
+	tb, eb := ttlBucket(bucketName), expiryBucket(bucketName)
+	if err := tx.tx.createBucket(tb); err != nil {
+		return err
+	}
+	if err := tx.tx.createBucket(eb); err != nil {
+		return err
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, deadline)
+	if err := tx.tx.put(key, tb, buf); err != nil {
+		return err
+	}
+	return tx.tx.put(expiryEntry(deadline, key), eb, []byte{})
+}
+
+// clearDeadline make the record permanent
+func (tx *Tx) clearDeadline(key, bucketName string) error {
+	deadline, ok, err := tx.deadline(key, bucketName)
+	if err != nil || !ok {
+		return err
+	}
+	if err := tx.tx.delete(expiryEntry(deadline, key), expiryBucket(bucketName)); err != nil {
+		return err
+	}
+	return tx.tx.delete(key, ttlBucket(bucketName))
+}
+
+func (tx *Tx) expired(key, bucketName string) (bool, error) {
+	deadline, ok, err := tx.deadline(key, bucketName)
+	if err != nil || !ok {
+		return false, err
+	}
+	return int64(deadline) <= now().UnixNano(), nil
+}
+
+// expiredFilter return a function reporting expired keys of bucket,
+// it is nil when bucket has no expiring record
+func (tx *Tx) expiredFilter(bucketName string) (func(key []byte) (bool, error), error) {
+	ok, err := tx.tx.bucketExist(ttlBucket(bucketName))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return func(key []byte) (bool, error) {
+		return tx.expired(string(key), bucketName)
+	}, nil
+}
+
+// DeleteExpired delete up to limit expired records of bucket, the oldest
+// first. zero limit mean no limit. it return the number of deleted records,
+// expiry entries left without a record are removed but not counted
+func (tx *Tx) DeleteExpired(bucketName string, limit int) (int, error) {
+	deleted, _, err := tx.deleteExpired(bucketName, limit)
+	return deleted, err
+}
+
+// deleteExpired remove up to limit due expiry entries of bucket and their
+// records, it return the number of deleted records and removed entries
+func (tx *Tx) deleteExpired(bucketName string, limit int) (int, int, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, 0, err
+	}
+	eb := expiryBucket(bucketName)
+	ok, err := tx.tx.bucketExist(eb)
+	if err != nil || !ok {
+		return 0, 0, err
+	}
+
+	// collect first, a bucket should not be changed while iterating it
+	c, err := tx.tx.cursor(eb)
+	if err != nil {
+		return 0, 0, err
+	}
+	current := uint64(now().UnixNano())
+	var entries []string
+	for k, _ := c.First(); k != nil && (limit == 0 || len(entries) < limit); k, _ = c.Next() {
+		if len(k) < 8 || binary.BigEndian.Uint64(k[:8]) > current {
+			break
+		}
+		entries = append(entries, string(k))
+	}
+	if err := cursorErr(c); err != nil {
+		return 0, 0, err
+	}
+
+	exist, err := tx.tx.bucketExist(bucketName)
+	if err != nil {
+		return 0, 0, err
+	}
+	deleted := 0
+	for _, entry := range entries {
+		key := entry[8:]
+		// an entry is orphan when its record is gone or has a new deadline
+		deadline, ok, err := tx.deadline(key, bucketName)
+		if err != nil {
+			return 0, 0, err
+		}
+		if exist && ok && expiryEntry(deadline, key) == entry {
+			v, err := tx.tx.get(key, bucketName)
+			if err != nil {
+				return 0, 0, err
+			}
+			if v == nil {
+				if err := tx.clearDeadline(key, bucketName); err != nil {
+					return 0, 0, err
+				}
+			} else {
+				if err := tx.delete(key, bucketName); err != nil {
+					return 0, 0, err
+				}
+				deleted++
+			}
+		}
+		if err := tx.tx.delete(entry, eb); err != nil {
+			return 0, 0, err
+		}
+	}
+	return deleted, len(entries), nil
+}
+
+// SweepExpired delete expired records of all buckets, in transactions of
+// sweepBatch records. it is no-op in read-only mode
+func SweepExpired(s Store) (int, error) {
+	if s.ReadOnly() {
+		return 0, nil
+	}
+	var buckets []string
+	err := s.View(func(tx *Tx) error {
+		all, err := tx.tx.buckets()
+		if err != nil {
+			return err
+		}
+		prefix := expiryBucket("")
+		for _, name := range all {
+			if strings.HasPrefix(name, prefix) {
+				buckets = append(buckets, strings.TrimPrefix(name, prefix))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, bucketName := range buckets {
+		for {
+			var deleted, removed int
+			err := s.Update(func(tx *Tx) error {
+				var err error
+				deleted, removed, err = tx.deleteExpired(bucketName, sweepBatch)
+				return err
+			})
+			if err != nil {
+				return total, err
+			}
+			total += deleted
+			if removed < sweepBatch {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// scheduleSweep delete expired records every interval until ctx is done
+func scheduleSweep(ctx *projectx.Ctx, s Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := SweepExpired(s); err != nil {
+				log.Printf("sweep expired records failed: %s", err)
+			} else if n > 0 {
+				log.Printf("%d expired records deleted", n)
+			}
+		}
+	}
+}
+
+func init() {
+	// start the sweeper of expired records, zero interval disable it
+	registry.Register(func(ctx *projectx.Ctx) error {
+		interval, err := time.ParseDuration(sweepInterval.String())
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid db.ttl.sweep_interval `%s`", sweepInterval.String())
+		}
+		if interval == 0 {
+			return nil
+		}
+		i, ok := ctx.Get(ContextKey)
+		if !ok {
+			return fmt.Errorf("could not get database from context")
+		}
+		ctx.Go(func() {
+			scheduleSweep(ctx, i.(Store), interval)
+		})
+		return nil
+	}, 2, false)
+}
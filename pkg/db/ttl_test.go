@@ -0,0 +1,179 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setClock make now return a clock which can be moved forward by the returned function
+func setClock(t *testing.T) func(d time.Duration) {
+	current := time.Date(2020, 10, 19, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	t.Cleanup(func() { now = time.Now })
+	return func(d time.Duration) {
+		current = current.Add(d)
+	}
+}
+
+func testStoreTTL(t *testing.T, s Store) {
+	advance := setClock(t)
+	mustCreateBucket(t, s, "test")
+
+	if err := s.SetWithTTL("a", "test", []byte("1"), 0); err == nil {
+		t.Error("set with zero ttl should fail")
+	}
+	if err := s.SetWithTTL("a", "test", []byte("1"), time.Minute); err != nil {
+		t.Fatalf("set with ttl failed %s", err)
+	}
+	mustSet(t, s, "test", "b", "2")
+	if err := s.SetJsonWithTTL("c", "test", "3", time.Hour); err != nil {
+		t.Fatalf("set json with ttl failed %s", err)
+	}
+
+	if ttl, err := s.TTL("a", "test"); err != nil || ttl != time.Minute {
+		t.Errorf("unexpected ttl %s, %v", ttl, err)
+	}
+	if ttl, err := s.TTL("b", "test"); err != nil || ttl != 0 {
+		t.Errorf("permanent record should have no ttl, got %s, %v", ttl, err)
+	}
+
+	advance(2 * time.Minute)
+	if _, err := s.GetOne("a", "test"); err == nil {
+		t.Error("expired record should not be readable")
+	}
+	if exist, _ := s.IsExist("a", "test"); exist {
+		t.Error("expired record should not exist")
+	}
+	all, err := s.GetAll("test")
+	if err != nil || len(all) != 2 || all[0].Key != "b" || all[1].Key != "c" {
+		t.Errorf("expired record should be skipped, got %v, %v", all, err)
+	}
+	keys := scanKeys(t, s, "test", ScanOptions{Reverse: true})
+	if strings.Join(keys, ",") != "c,b" {
+		t.Errorf("expired record should be skipped in reverse scan, got %v", keys)
+	}
+
+	// expired record is deleted by the sweeper
+	err = s.Update(func(tx *Tx) error {
+		n, err := tx.DeleteExpired("test", 0)
+		if n != 1 {
+			t.Errorf("expected one expired record deleted but got %d", n)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("delete expired failed %s", err)
+	}
+	_ = s.View(func(tx *Tx) error {
+		if v, _ := tx.tx.get("a", "test"); v != nil {
+			t.Error("expired record should be deleted")
+		}
+		return nil
+	})
+
+	// set make a record permanent
+	mustSet(t, s, "test", "c", "4")
+	advance(2 * time.Hour)
+	if v, err := s.GetOne("c", "test"); err != nil || string(v) != "4" {
+		t.Errorf("record should not expire after set, got %s, %v", v, err)
+	}
+
+	if err := s.DeleteBucket("test"); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.View(func(tx *Tx) error {
+		all, _ := tx.tx.buckets()
+		for _, name := range all {
+			if strings.HasPrefix(name, internalPrefix+"ttl.") || strings.HasPrefix(name, internalPrefix+"expiry.") {
+				t.Errorf("bucket %s should be dropped with its bucket", name)
+			}
+		}
+		return nil
+	})
+}
+
+func scanKeys(t *testing.T, s Store, bucket string, opts ScanOptions) []string {
+	t.Helper()
+	var keys []string
+	_, err := s.Scan(bucket, opts, func(item Item) error {
+		keys = append(keys, item.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed %s", err)
+	}
+	return keys
+}
+
+func TestSweepExpired(t *testing.T) {
+	advance := setClock(t)
+	s := NewMemory()
+	mustCreateBucket(t, s, "a")
+	mustCreateBucket(t, s, "b")
+
+	for i := 0; i < sweepBatch+10; i++ {
+		if err := s.SetWithTTL(fmt.Sprintf("%05d", i), "a", []byte("v"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetWithTTL("1", "b", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetWithTTL("2", "b", []byte("v"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	advance(2 * time.Minute)
+	n, err := SweepExpired(s)
+	if err != nil || n != sweepBatch+11 {
+		t.Errorf("expected %d records swept but got %d, %v", sweepBatch+11, n, err)
+	}
+	if exist, _ := s.IsExist("2", "b"); !exist {
+		t.Error("record not expired yet should be kept")
+	}
+}
+
+func TestSweepExpired_Orphans(t *testing.T) {
+	advance := setClock(t)
+	s := NewMemory()
+	mustCreateBucket(t, s, "a")
+	if err := s.SetWithTTL("live", "a", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// expiry entries whose records are already gone
+	err := s.Update(func(tx *Tx) error {
+		deadline := uint64(now().Add(time.Minute).UnixNano())
+		for i := 0; i < sweepBatch+5; i++ {
+			if err := tx.tx.put(expiryEntry(deadline, fmt.Sprintf("gone%05d", i)), expiryBucket("a"), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	advance(2 * time.Minute)
+	if n, err := SweepExpired(s); err != nil || n != 1 {
+		t.Errorf("only the live record should be counted, got %d, %v", n, err)
+	}
+	err = s.View(func(tx *Tx) error {
+		c, err := tx.tx.cursor(expiryBucket("a"))
+		if err != nil {
+			return err
+		}
+		if k, _ := c.First(); k != nil {
+			t.Errorf("orphan expiry entries should be removed, found %q", k)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := SweepExpired(s); err != nil || n != 0 {
+		t.Errorf("nothing should be left to sweep, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,371 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Tx is a read-only or read-write transaction, all operations done
+// through a Tx are committed or rolled back together
+type Tx struct {
+	tx       txBackend
+	writable bool
+}
+
+// txBackend is the set of primitives a backend should provide in a transaction
+type txBackend interface {
+	buckets() ([]string, error)
+	createBucket(bucketName string) error
+	deleteBucket(bucketName string) error
+	bucketExist(bucketName string) (bool, error)
+	// get return nil if key not exist
+	get(key, bucketName string) ([]byte, error)
+	put(key, bucketName string, value []byte) error
+	delete(key, bucketName string) error
+	cursor(bucketName string) (Cursor, error)
+}
+
+// Cursor iterate over keys of a bucket in byte-sorted order, a nil key
+// mean the cursor has reached to the beginning or end of the bucket
+type Cursor interface {
+	First() (key, value []byte)
+	Last() (key, value []byte)
+	Seek(seek []byte) (key, value []byte)
+	Next() (key, value []byte)
+	Prev() (key, value []byte)
+}
+
+// cursorErr return the error a cursor hit while iterating, if it can fail at all
+func cursorErr(c Cursor) error {
+	if e, ok := c.(interface{ Err() error }); ok {
+		return e.Err()
+	}
+	return nil
+}
+
+// internalPrefix is reserved for buckets the db layer maintain itself,
+// like record versions, they are hidden from Buckets
+const internalPrefix = "__"
+
+func isInternalBucket(bucketName string) bool {
+	return strings.HasPrefix(bucketName, internalPrefix)
+}
+
+// ErrNotFound is matched by errors.Is for errors of reading a key which not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrBucketNotFound is matched by errors.Is for errors of using a bucket which not exist
+var ErrBucketNotFound = errors.New("bucket not found")
+
+func bucketNotExist(bucketName string) error {
+	return fmt.Errorf("%w: `%s`", ErrBucketNotFound, bucketName)
+}
+
+func keyNotExist(key, bucketName string) error {
+	return fmt.Errorf("%w: key `%s` of bucket `%s`", ErrNotFound, key, bucketName)
+}
+
+// Writable return true if tx can be used to modify data
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+func (tx *Tx) checkWritable() error {
+	if !tx.writable {
+		return fmt.Errorf("tx not writable")
+	}
+	return nil
+}
+
+func (tx *Tx) checkBucket(bucketName string) error {
+	ok, err := tx.tx.bucketExist(bucketName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return bucketNotExist(bucketName)
+	}
+	return nil
+}
+
+// Buckets return sorted name of all buckets
+func (tx *Tx) Buckets() ([]string, error) {
+	all, err := tx.tx.buckets()
+	if err != nil {
+		return nil, err
+	}
+	var buckets []string
+	for _, name := range all {
+		if !isInternalBucket(name) {
+			buckets = append(buckets, name)
+		}
+	}
+	return buckets, nil
+}
+
+func (tx *Tx) CreateBucket(bucketName string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if bucketName == "" {
+		return fmt.Errorf("create bucket: `bucket name required`")
+	}
+	if isInternalBucket(bucketName) {
+		return fmt.Errorf("create bucket: `bucket name %s is reserved`", bucketName)
+	}
+	if err := tx.tx.createBucket(bucketName); err != nil {
+		return fmt.Errorf("create bucket: `%s`", err)
+	}
+	return tx.buildMissingIndexes(bucketName)
+}
+
+func (tx *Tx) DeleteBucket(bucketName string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	ok, err := tx.tx.bucketExist(bucketName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("delete bucket: %w", bucketNotExist(bucketName))
+	}
+	if err := tx.tx.deleteBucket(bucketName); err != nil {
+		return fmt.Errorf("delete bucket: `%s`", err)
+	}
+	// drop companion buckets
+	for _, name := range companionBuckets(bucketName) {
+		ok, err := tx.tx.bucketExist(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := tx.tx.deleteBucket(name); err != nil {
+			return err
+		}
+	}
+	if err := tx.resetChanges(bucketName); err != nil {
+		return err
+	}
+	return tx.unframe(bucketName)
+}
+
+// companionBuckets return internal buckets the db layer keep for bucketName,
+// the change log is reset by resetChanges instead so its seq is kept
+func companionBuckets(bucketName string) []string {
+	names := []string{versionBucket(bucketName), ttlBucket(bucketName), expiryBucket(bucketName)}
+	for _, idx := range bucketIndexes(bucketName) {
+		names = append(names, indexBucket(bucketName, idx.Name))
+	}
+	return names
+}
+
+func (tx *Tx) IsExist(key, bucketName string) (bool, error) {
+	if err := tx.checkBucket(bucketName); err != nil {
+		return false, err
+	}
+	v, err := tx.tx.get(key, bucketName)
+	if err != nil || v == nil {
+		return false, err
+	}
+	expired, err := tx.expired(key, bucketName)
+	return !expired, err
+}
+
+func (tx *Tx) Set(key, bucketName string, value []byte) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.checkBucket(bucketName); err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("key required")
+	}
+	return tx.put(key, bucketName, value)
+}
+
+// put write the value and update its companion records
+func (tx *Tx) put(key, bucketName string, value []byte) error {
+	old, err := tx.getValue(key, bucketName)
+	if err != nil {
+		return err
+	}
+	if err := tx.putValue(key, bucketName, value); err != nil {
+		return err
+	}
+	if _, err := tx.bumpVersion(key, bucketName); err != nil {
+		return err
+	}
+	if err := tx.clearDeadline(key, bucketName); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	if err := tx.updateIndexes(key, bucketName, old, value); err != nil {
+		return err
+	}
+	return tx.logChange(key, bucketName, OpSet)
+}
+
+func (tx *Tx) GetOne(key, bucketName string) ([]byte, error) {
+	if err := tx.checkBucket(bucketName); err != nil {
+		return nil, err
+	}
+	expired, err := tx.expired(key, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	v, err := tx.getValue(key, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || expired {
+		return nil, keyNotExist(key, bucketName)
+	}
+	return v, nil
+}
+
+func (tx *Tx) GetAll(bucketName string) ([]KeyVal, error) {
+	c, err := tx.Cursor(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var result []KeyVal
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		result = append(result, KeyVal{
+			Key: string(k),
+			Val: copyBytes(v),
+		})
+	}
+	return result, cursorErr(c)
+}
+
+// Cursor return a cursor over bucket keys, it is only valid during the transaction
+func (tx *Tx) Cursor(bucketName string) (Cursor, error) {
+	if err := tx.checkBucket(bucketName); err != nil {
+		return nil, err
+	}
+	c, err := tx.tx.cursor(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := tx.expiredFilter(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	framed, err := tx.framed(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return &valueCursor{c: c, bucketName: bucketName, framed: framed, expired: expired}, nil
+}
+
+func (tx *Tx) Delete(key, bucketName string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.checkBucket(bucketName); err != nil {
+		return err
+	}
+	return tx.delete(key, bucketName)
+}
+
+// delete remove the key and its companion records
+func (tx *Tx) delete(key, bucketName string) error {
+	old, err := tx.getValue(key, bucketName)
+	if err != nil || old == nil {
+		return err
+	}
+	if err := tx.tx.delete(key, bucketName); err != nil {
+		return err
+	}
+	if err := tx.dropVersion(key, bucketName); err != nil {
+		return err
+	}
+	if err := tx.clearDeadline(key, bucketName); err != nil {
+		return err
+	}
+	if err := tx.updateIndexes(key, bucketName, old, nil); err != nil {
+		return err
+	}
+	return tx.logChange(key, bucketName, OpDelete)
+}
+
+func (tx *Tx) BatchDelete(keys []string, bucketName string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.checkBucket(bucketName); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := tx.delete(key, bucketName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetJson encode value with the bucket codec, JSON by default, and save it
+func (tx *Tx) SetJson(key, bucketName string, value interface{}) error {
+	// Marshal and save the encoded data.
+	buf, err := encodeValue(bucketName, value)
+	if err != nil {
+		return err
+	}
+	return tx.Set(key, bucketName, buf)
+}
+
+func (tx *Tx) GetJson(key, bucketName string, ret interface{}) error {
+	data, err := tx.GetOne(key, bucketName)
+	if err != nil {
+		return err
+	}
+	if err := decodeValue(data, ret); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (tx *Tx) GetJsonList(bucketName string, ret interface{}) error {
+	data, err := tx.GetAll(bucketName)
+	if err != nil {
+		return err
+	}
+	return decodeJsonList(data, ret)
+}
+
+// decodeJsonList decode values of data into ret which should be a pointer to slice
+func decodeJsonList(data []KeyVal, ret interface{}) error {
+
+	v := reflect.ValueOf(ret)
+	if v.Kind() != reflect.Ptr {
+		return fmt.Errorf("non-pointer %v", v.Type())
+	}
+	// get the value that the pointer v points to.
+	v = v.Elem()
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("can't fill non-slice value")
+	}
+
+	dataLen := len(data)
+
+	v.Set(reflect.MakeSlice(v.Type(), dataLen, dataLen))
+
+	for i, kVal := range data {
+		// a new value for each record, codecs like gob do not
+		// overwrite fields which are zero in the record
+		realVal := reflect.New(v.Type().Elem()).Interface()
+		if err := decodeValue(kVal.Val, realVal); err != nil {
+			return err
+		}
+		v.Index(i).Set(reflect.ValueOf(realVal).Elem())
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package db
+
+import "fmt"
+
+// framedBucket hold names of user buckets whose values are framed, the first
+// byte of a framed value tell how it is stored:
+//
+//	0x10-0x17 compressed, see Compression
+//	0x18      encrypted, see RegisterEncryption
+//	0x19      plain value which start with a reserved byte, see escapeTag
+//	others    plain value
+//
+// a bucket is framed on its first write after compression or encryption is
+// registered for it and stay framed, so its values are readable after they
+// are turned off. values of other buckets are stored as they are.
+// codec tags 0x01-0x08 belong to the plain value, see Codec
+const framedBucket = internalPrefix + "framed"
+
+// escapeTag is stored before plain values of framed buckets which start
+// with a reserved byte, so they are not taken for a header
+const escapeTag byte = 0x19
+
+// reservedByte return true if b is a header of framed values
+func reservedByte(b byte) bool {
+	return b >= MinCompressionTag && b <= escapeTag
+}
+
+// escape prefix value with escapeTag if it start with a reserved byte
+func escape(value []byte) []byte {
+	if len(value) == 0 || !reservedByte(value[0]) {
+		return value
+	}
+	return append([]byte{escapeTag}, value...)
+}
+
+// needFrame return true if values of bucket are compressed or encrypted
+func needFrame(bucketName string) bool {
+	return BucketCompression(bucketName) != nil || BucketEncrypted(bucketName)
+}
+
+// encodeStored prepare a value of a framed bucket to be stored, it is
+// compressed and then encrypted when the bucket is configured so.
+// decodeStored revert it
+func encodeStored(bucketName, key string, value []byte) ([]byte, error) {
+	buf, err := compress(bucketName, value)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(bucketName, key, buf)
+}
+
+func decodeStored(bucketName, key string, value []byte) ([]byte, error) {
+	buf, err := decrypt(bucketName, key, value)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(buf)
+}
+
+// framed return true if values of bucket are framed
+func (tx *Tx) framed(bucketName string) (bool, error) {
+	ok, err := tx.tx.bucketExist(framedBucket)
+	if err != nil || !ok {
+		return false, err
+	}
+	v, err := tx.tx.get(bucketName, framedBucket)
+	return v != nil, err
+}
+
+// frame mark bucket as framed, plain values already stored
+// which start with a reserved byte are escaped
+func (tx *Tx) frame(bucketName string) error {
+	// collect first, a bucket should not be changed while iterating it
+	c, err := tx.tx.cursor(bucketName)
+	if err != nil {
+		return err
+	}
+	var data []KeyVal
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if len(v) > 0 && reservedByte(v[0]) {
+			data = append(data, KeyVal{Key: string(k), Val: escape(v)})
+		}
+	}
+	if err := cursorErr(c); err != nil {
+		return err
+	}
+	for _, kVal := range data {
+		if err := tx.tx.put(kVal.Key, bucketName, kVal.Val); err != nil {
+			return err
+		}
+	}
+	if err := tx.tx.createBucket(framedBucket); err != nil {
+		return err
+	}
+	return tx.tx.put(bucketName, framedBucket, []byte{1})
+}
+
+// unframe forget a deleted bucket was framed
+func (tx *Tx) unframe(bucketName string) error {
+	ok, err := tx.tx.bucketExist(framedBucket)
+	if err != nil || !ok {
+		return err
+	}
+	return tx.tx.delete(bucketName, framedBucket)
+}
+
+// getValue return the plain value of key in a user bucket, nil if key not exist
+func (tx *Tx) getValue(key, bucketName string) ([]byte, error) {
+	v, err := tx.tx.get(key, bucketName)
+	if err != nil || v == nil {
+		return v, err
+	}
+	framed, err := tx.framed(bucketName)
+	if err != nil || !framed {
+		return v, err
+	}
+	return decodeStored(bucketName, key, v)
+}
+
+// putValue store value of key in a user bucket
+func (tx *Tx) putValue(key, bucketName string, value []byte) error {
+	framed, err := tx.framed(bucketName)
+	if err != nil {
+		return err
+	}
+	if !framed && needFrame(bucketName) {
+		if err := tx.frame(bucketName); err != nil {
+			return err
+		}
+		framed = true
+	}
+	if framed {
+		if value, err = encodeStored(bucketName, key, value); err != nil {
+			return err
+		}
+	}
+	return tx.tx.put(key, bucketName, value)
+}
+
+// valueCursor return plain values of a user bucket and skip expired records,
+// iteration stops at the first value which can not be decoded
+type valueCursor struct {
+	c          Cursor
+	bucketName string
+	framed     bool
+	// expired is nil when bucket has no expiring record
+	expired func(key []byte) (bool, error)
+	err     error
+}
+
+// visit skip expired records moving by step and return the first live one
+func (vc *valueCursor) visit(k, v []byte, step func() ([]byte, []byte)) ([]byte, []byte) {
+	for k != nil && vc.err == nil && vc.expired != nil {
+		expired, err := vc.expired(k)
+		if err != nil {
+			vc.err = err
+			break
+		}
+		if !expired {
+			break
+		}
+		k, v = step()
+	}
+	if k == nil || vc.err != nil {
+		return nil, nil
+	}
+	if !vc.framed {
+		return k, v
+	}
+	v, err := decodeStored(vc.bucketName, string(k), v)
+	if err != nil {
+		vc.err = fmt.Errorf("key `%s`: %s", k, err)
+		return nil, nil
+	}
+	return k, v
+}
+
+func (vc *valueCursor) First() ([]byte, []byte) {
+	k, v := vc.c.First()
+	return vc.visit(k, v, vc.c.Next)
+}
+
+func (vc *valueCursor) Last() ([]byte, []byte) {
+	k, v := vc.c.Last()
+	return vc.visit(k, v, vc.c.Prev)
+}
+
+func (vc *valueCursor) Seek(seek []byte) ([]byte, []byte) {
+	k, v := vc.c.Seek(seek)
+	return vc.visit(k, v, vc.c.Next)
+}
+
+func (vc *valueCursor) Next() ([]byte, []byte) {
+	k, v := vc.c.Next()
+	return vc.visit(k, v, vc.c.Next)
+}
+
+func (vc *valueCursor) Prev() ([]byte, []byte) {
+	k, v := vc.c.Prev()
+	return vc.visit(k, v, vc.c.Prev)
+}
+
+func (vc *valueCursor) Err() error {
+	if vc.err != nil {
+		return vc.err
+	}
+	return cursorErr(vc.c)
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrConflict is matched by errors.Is for all *ConflictError errors
+var ErrConflict = errors.New("version conflict")
+
+// ConflictError is returned when a compare-and-set write find
+// the record in a different version than expected
+type ConflictError struct {
+	Bucket   string
+	Key      string
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s on key `%s` of bucket `%s`: expected %d, actual %d",
+		ErrConflict, e.Key, e.Bucket, e.Expected, e.Actual)
+}
+
+// Is make errors.Is(err, ErrConflict) true
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// versionBucket is the companion bucket holding record versions of bucketName
+func versionBucket(bucketName string) string {
+	return internalPrefix + "version." + bucketName
+}
+
+// Version return the version of a record, it is increased on every
+// write and is zero for records that not exist or written before versioning
+func (tx *Tx) Version(key, bucketName string) (uint64, error) {
+	if err := tx.checkBucket(bucketName); err != nil {
+		return 0, err
+	}
+	return tx.version(key, bucketName)
+}
+
+func (tx *Tx) version(key, bucketName string) (uint64, error) {
+	vb := versionBucket(bucketName)
+	ok, err := tx.tx.bucketExist(vb)
+	if err != nil || !ok {
+		return 0, err
+	}
+	v, err := tx.tx.get(key, vb)
+	if err != nil || len(v) != 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// bumpVersion increase the record version and return the new one
+func (tx *Tx) bumpVersion(key, bucketName string) (uint64, error) {
+	current, err := tx.version(key, bucketName)
+	if err != nil {
+		return 0, err
+	}
+	vb := versionBucket(bucketName)
+	if err := tx.tx.createBucket(vb); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, current+1)
+	return current + 1, tx.tx.put(key, vb, buf)
+}
+
+func (tx *Tx) dropVersion(key, bucketName string) error {
+	vb := versionBucket(bucketName)
+	ok, err := tx.tx.bucketExist(vb)
+	if err != nil || !ok {
+		return err
+	}
+	return tx.tx.delete(key, vb)
+}
+
+func (tx *Tx) checkVersion(key, bucketName string, version uint64) error {
+	current, err := tx.Version(key, bucketName)
+	if err != nil {
+		return err
+	}
+	if current != version {
+		return &ConflictError{Bucket: bucketName, Key: key, Expected: version, Actual: current}
+	}
+	return nil
+}
+
+// SetIfVersion write value only if record is still in the given version,
+// a *ConflictError is returned otherwise
+func (tx *Tx) SetIfVersion(key, bucketName string, value []byte, version uint64) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.checkVersion(key, bucketName, version); err != nil {
+		return err
+	}
+	return tx.Set(key, bucketName, value)
+}
+
+// SetJsonIfVersion is the json version of SetIfVersion
+func (tx *Tx) SetJsonIfVersion(key, bucketName string, value interface{}, version uint64) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.checkVersion(key, bucketName, version); err != nil {
+		return err
+	}
+	return tx.SetJson(key, bucketName, value)
+}
+
+// DeleteIfVersion delete the key only if record is still in the given version,
+// a *ConflictError is returned otherwise
+func (tx *Tx) DeleteIfVersion(key, bucketName string, version uint64) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.checkVersion(key, bucketName, version); err != nil {
+		return err
+	}
+	return tx.Delete(key, bucketName)
+}
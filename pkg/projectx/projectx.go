@@ -13,6 +13,9 @@ type Ctx struct {
 
 	// Keys is a key/value pair
 	Keys map[string]interface{}
+
+	// jobs track background goroutines started by Go
+	jobs sync.WaitGroup
 }
 
 // New return a new instance of project context
@@ -43,7 +46,34 @@ func (c *Ctx) Get(key string) (value interface{}, exists bool) {
 	return
 }
 
-// Done always returns nil (chan which will wait forever),
+// Done returns the done channel of parent context, it is closed when
+// the project is stopping. it returns nil (wait forever) without parent
 func (c *Ctx) Done() <-chan struct{} {
-	return nil
+	if c.parent == nil {
+		return nil
+	}
+	return c.parent.Done()
+}
+
+// Context return the parent context, background if it has no parent
+func (c *Ctx) Context() context.Context {
+	if c.parent == nil {
+		return context.Background()
+	}
+	return c.parent
+}
+
+// Go run fn in a background goroutine, fn should return soon after Done
+// is closed. Wait can be used to wait for all of them to return
+func (c *Ctx) Go(fn func()) {
+	c.jobs.Add(1)
+	go func() {
+		defer c.jobs.Done()
+		fn()
+	}()
+}
+
+// Wait block until all goroutines started by Go are returned
+func (c *Ctx) Wait() {
+	c.jobs.Wait()
 }
@@ -58,4 +58,20 @@ func TestCtx_Get_NotExist(t *testing.T) {
 	if v!=nil {
 		t.Errorf("value should be nil but is %v", v)
 	}
-}
\ No newline at end of file
+}
+func TestCtx_Go(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	pctx := New(parent)
+
+	stopped := false
+	pctx.Go(func() {
+		<-pctx.Done()
+		stopped = true
+	})
+
+	cancel()
+	pctx.Wait()
+	if !stopped {
+		t.Error("wait returned before job stopped")
+	}
+}
@@ -93,3 +93,33 @@ func TestRegister(t *testing.T) {
 		t.Errorf("expected to run two task but runs: %d", runs)
 	}
 }
+
+func TestRun_FailOnErrorSucceed(t *testing.T) {
+
+	ctx := projectx.New(context.Background())
+	defer Flush()
+
+	a := func(ctx *projectx.Ctx) error {
+		return nil
+	}
+
+	b := func(ctx *projectx.Ctx) error {
+		return fmt.Errorf("func error")
+	}
+
+	Flush()
+
+	// a successful item which must not fail does not stop the run
+	Register(a, 0, true)
+	Register(a, 1, false)
+	Register(b, 2, false)
+
+	runs, errors := Run(ctx)
+	if len(errors) != 1 {
+		t.Errorf("expected one error returned: %d", len(errors))
+	}
+
+	if runs != 3 {
+		t.Errorf("expected to run three task but runs: %d", runs)
+	}
+}
@@ -52,9 +52,9 @@ func Run(ctx *projectx.Ctx) (int, []error) {
 		err = item.function(ctx)
 		if err != nil {
 			errors = append(errors, err)
-		}
-		if item.failOnError {
-			return i + 1, errors
+			if item.failOnError {
+				return i + 1, errors
+			}
 		}
 	}
 	return len(items), errors
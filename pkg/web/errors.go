@@ -0,0 +1,27 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/db"
+)
+
+// StatusCode return the http status matching err, errors of the db
+// package are matched with errors.Is so they can be wrapped with context.
+// unknown errors are 500 Internal Server Error
+func StatusCode(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrConflict), errors.Is(err, db.ErrUniqueViolation):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrReadOnly):
+		return http.StatusServiceUnavailable
+	default:
+		// a missing bucket is a server side problem too
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,30 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/db"
+)
+
+func TestStatusCode(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{nil, http.StatusOK},
+		{fmt.Errorf("get task: %w", db.ErrNotFound), http.StatusNotFound},
+		{&db.ConflictError{Bucket: "b", Key: "k", Expected: 1, Actual: 2}, http.StatusConflict},
+		{fmt.Errorf("wrapped: %w", &db.UniqueError{Bucket: "b", Field: "f"}), http.StatusConflict},
+		{db.ErrReadOnly, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: `tasks`", db.ErrBucketNotFound), http.StatusInternalServerError},
+		{errors.New("unknown"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if got := StatusCode(c.err); got != c.status {
+			t.Errorf("expected status %d for %v but got %d", c.status, c.err, got)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+)
+
+// ContextKey is the key http router is stored in project context with
+const ContextKey = "web"
+
+var addr = config.RegisterString("web.addr", ":8080")
+
+// New return a new http router
+func New() *gin.Engine {
+	return gin.Default()
+}
+
+// Router return the http router stored in project context, it
+// is not set when running commands that do not serve http
+func Router(ctx *projectx.Ctx) (*gin.Engine, bool) {
+	i, ok := ctx.Get(ContextKey)
+	if !ok {
+		return nil, false
+	}
+	router, ok := i.(*gin.Engine)
+	return router, ok
+}
+
+// shutdownTimeout is how long in-flight requests are waited for on stop
+const shutdownTimeout = 10 * time.Second
+
+// Run serve router on the configured address until ctx is done,
+// then wait for in-flight requests before returning
+func Run(ctx *projectx.Ctx, router *gin.Engine) error {
+	srv := &http.Server{Addr: addr.String(), Handler: router}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,25 @@
+package web
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+)
+
+func TestRouter(t *testing.T) {
+	ctx := projectx.New(context.Background())
+
+	_, ok := Router(ctx)
+	if ok {
+		t.Error("router should not exist in empty context")
+	}
+
+	router := New()
+	ctx.Set(ContextKey, router)
+
+	r, ok := Router(ctx)
+	if !ok || r != router {
+		t.Error("router is not same as stored one")
+	}
+}
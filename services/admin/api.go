@@ -0,0 +1,117 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/db"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
+	"github.com/mirzakhany/rest_api_sample/pkg/registry"
+	"github.com/mirzakhany/rest_api_sample/pkg/web"
+)
+
+// token is the bearer token admin endpoints require,
+// admin endpoints are disabled when it is empty
+var token = config.RegisterString("admin.token", "")
+
+// RegisterRoutes register admin http handlers on router, every
+// handler requires `Authorization: Bearer <token>` header
+func RegisterRoutes(router gin.IRouter, token string, store db.Store) {
+	router.Use(requireToken(token))
+	router.GET("/backup", backup(store))
+	router.GET("/stats", stats(store))
+	router.GET("/metrics", gin.WrapH(expvar.Handler()))
+	router.GET("/read-only", getReadOnly(store))
+	router.PUT("/read-only", setReadOnly(store))
+}
+
+// readOnlyMode is the body of read-only endpoints
+type readOnlyMode struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+// requireToken abort requests which do not carry token
+func requireToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// backup stream a consistent snapshot of the database as a bbolt file
+func backup(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := fmt.Sprintf("backup-%s.db", time.Now().UTC().Format("20060102T150405Z"))
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		c.Status(http.StatusOK)
+		if _, err := store.Backup(c.Writer); err != nil {
+			// headers are already sent, the client gets a truncated body
+			log.Printf("backup failed: %s", err)
+			_ = c.Error(err)
+		}
+	}
+}
+
+// stats return database stats, like file size, free pages and open transactions
+func stats(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s, err := store.Stats()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, s)
+	}
+}
+
+// getReadOnly report if the database is in read-only mode
+func getReadOnly(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, readOnlyMode{ReadOnly: store.ReadOnly()})
+	}
+}
+
+// setReadOnly switch maintenance read-only mode, writes
+// return 503 Service Unavailable while it is on
+func setReadOnly(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var mode readOnlyMode
+		if err := c.ShouldBindJSON(&mode); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := store.SetReadOnly(mode.ReadOnly); err != nil {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("database read-only mode set to %t", mode.ReadOnly)
+		c.JSON(http.StatusOK, readOnlyMode{ReadOnly: store.ReadOnly()})
+	}
+}
+
+func init() {
+	registry.Register(func(ctx *projectx.Ctx) error {
+		router, ok := web.Router(ctx)
+		if !ok || token.String() == "" {
+			return nil
+		}
+		i, ok := ctx.Get(db.ContextKey)
+		if !ok {
+			return fmt.Errorf("could not get database from context")
+		}
+		RegisterRoutes(router.Group("/admin"), token.String(), i.(db.Store))
+		return nil
+	}, 5, true)
+}
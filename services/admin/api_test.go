@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mirzakhany/rest_api_sample/pkg/db"
+)
+
+const testToken = "secret"
+
+func newTestRouter(store db.Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRoutes(router.Group("/admin"), testToken, store)
+	return router
+}
+
+func doRequest(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPI_Token(t *testing.T) {
+	router := newTestRouter(db.NewMemory())
+
+	if w := doRequest(router, http.MethodGet, "/admin/backup", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without token but got %d", w.Code)
+	}
+	if w := doRequest(router, http.MethodGet, "/admin/backup", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 with wrong token but got %d", w.Code)
+	}
+}
+
+func TestAPI_Backup(t *testing.T) {
+	store := db.NewMemory()
+	if err := store.CreateBucket("tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("1", "tasks", []byte("task")); err != nil {
+		t.Fatal(err)
+	}
+
+	w := doRequest(newTestRouter(store), http.MethodGet, "/admin/backup", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", w.Code)
+	}
+
+	file := filepath.Join(t.TempDir(), "backup.db")
+	if err := ioutil.WriteFile(file, w.Body.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ValidateBackup(file); err != nil {
+		t.Fatalf("streamed backup is invalid: %s", err)
+	}
+
+	restored, err := db.New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	val, err := restored.GetOne("1", "tasks")
+	if err != nil || string(val) != "task" {
+		t.Errorf("unexpected value in backup %q, %v", val, err)
+	}
+}
+
+func TestAPI_Stats(t *testing.T) {
+	store := db.NewMemory()
+	if err := store.CreateBucket("tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("1", "tasks", []byte("task")); err != nil {
+		t.Fatal(err)
+	}
+	router := newTestRouter(store)
+
+	w := doRequest(router, http.MethodGet, "/admin/stats", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", w.Code)
+	}
+	var stats db.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Backend != db.MemoryBackend || len(stats.Buckets) == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	db.PublishStats(store)
+	w = doRequest(router, http.MethodGet, "/admin/metrics", testToken)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"db": {"backend":"memory"`) {
+		t.Errorf("expected db stats in metrics but got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAPI_ReadOnly(t *testing.T) {
+	store := db.NewMemory()
+	router := newTestRouter(store)
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/read-only", strings.NewReader(`{"read_only": true}`))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !store.ReadOnly() {
+		t.Fatalf("expected read-only mode to be on, got %d %s", w.Code, w.Body.String())
+	}
+	if err := store.CreateBucket("tasks"); err != db.ErrReadOnly {
+		t.Errorf("expected ErrReadOnly but got %v", err)
+	}
+
+	w = doRequest(router, http.MethodGet, "/admin/read-only", testToken)
+	if w.Code != http.StatusOK || w.Body.String() != `{"read_only":true}` {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
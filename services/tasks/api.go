@@ -1 +1,205 @@
 package tasks
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mirzakhany/rest_api_sample/pkg/config"
+	"github.com/mirzakhany/rest_api_sample/pkg/db"
+	"github.com/mirzakhany/rest_api_sample/pkg/web"
+)
+
+// maxPageSize is the maximum number of tasks returned in a single page
+const maxPageSize = 1000
+
+// nextCursorHeader is the response header holding cursor of the next page
+const nextCursorHeader = "X-Next-Cursor"
+
+var pageSize = config.RegisterInt64("tasks.page_size", 100)
+
+// RegisterRoutes register tasks http handlers on router
+func RegisterRoutes(router gin.IRouter) {
+	router.GET("", listTasks)
+	router.POST("", createTask)
+	router.GET("/:id", getTask)
+	router.PUT("/:id", updateTask)
+	router.DELETE("/:id", deleteTask)
+}
+
+// etag format record version as a strong entity tag
+func etag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// ifMatch parse If-Match header as a list of versions, ok is false when header
+// is not set or is `*` which both mean the write is unconditional.
+// If-Match use strong comparison, so weak tags never match and are skipped
+func ifMatch(c *gin.Context) (versions []uint64, ok bool, err error) {
+	h := strings.TrimSpace(c.GetHeader("If-Match"))
+	if h == "" || h == "*" {
+		return nil, false, nil
+	}
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		v, err := strconv.Unquote(tag)
+		if err != nil {
+			return nil, false, err
+		}
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, false, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, true, nil
+}
+
+// matchVersion return the current version of task if it is one of versions,
+// a *db.ConflictError is returned otherwise. the write should be done with
+// the returned version so a concurrent change still fail
+func matchVersion(taskID string, versions []uint64) (uint64, error) {
+	task, err := GetRepository().GetOne(taskID)
+	if err != nil {
+		return 0, err
+	}
+	for _, version := range versions {
+		if version == task.Version {
+			return version, nil
+		}
+	}
+	conflict := &db.ConflictError{Bucket: BucketName, Key: taskID, Actual: task.Version}
+	if len(versions) > 0 {
+		conflict.Expected = versions[0]
+	}
+	return 0, conflict
+}
+
+// abortWithError write error response with a status matching the error,
+// a version conflict is a failed If-Match precondition
+func abortWithError(c *gin.Context, err error) {
+	status := web.StatusCode(err)
+	if errors.Is(err, db.ErrConflict) {
+		status = http.StatusPreconditionFailed
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
+
+// listTasks return a page of tasks, cursor of the next page is set in
+// X-Next-Cursor header and should be passed back as `cursor` query param
+func listTasks(c *gin.Context) {
+	limit := pageSize.Int()
+	if l := c.Query("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	// cursor is opaque for clients
+	after, err := base64.RawURLEncoding.DecodeString(c.Query("cursor"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+		return
+	}
+
+	tasks, next, err := GetRepository().List(string(after), limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if tasks == nil {
+		tasks = []Task{}
+	}
+	if next != "" {
+		c.Header(nextCursorHeader, base64.RawURLEncoding.EncodeToString([]byte(next)))
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+func createTask(c *gin.Context) {
+	var req TaskRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	task, err := GetRepository().Create(req.Task())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("ETag", etag(task.Version))
+	c.JSON(http.StatusCreated, task)
+}
+
+func getTask(c *gin.Context) {
+	task, err := GetRepository().GetOne(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("ETag", etag(task.Version))
+	c.JSON(http.StatusOK, task)
+}
+
+func updateTask(c *gin.Context) {
+	var req TaskRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	versions, conditional, err := ifMatch(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "invalid If-Match header"})
+		return
+	}
+
+	var task Task
+	if conditional {
+		var version uint64
+		if version, err = matchVersion(c.Param("id"), versions); err == nil {
+			task, err = GetRepository().UpdateIfVersion(c.Param("id"), req.Task(), version)
+		}
+	} else {
+		task, err = GetRepository().Update(c.Param("id"), req.Task())
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("ETag", etag(task.Version))
+	c.JSON(http.StatusOK, task)
+}
+
+func deleteTask(c *gin.Context) {
+	versions, conditional, err := ifMatch(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "invalid If-Match header"})
+		return
+	}
+
+	if conditional {
+		var version uint64
+		if version, err = matchVersion(c.Param("id"), versions); err == nil {
+			err = GetRepository().DeleteIfVersion(c.Param("id"), version)
+		}
+	} else {
+		err = GetRepository().Delete(c.Param("id"))
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
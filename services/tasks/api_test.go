@@ -0,0 +1,201 @@
+package tasks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRoutes(router.Group("/tasks"))
+	return router
+}
+
+func doRequest(router *gin.Engine, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+const testTaskBody = `{"title":"test1","sprint":"bar","estimate":"1","status":"in-progress","assignee":"foo"}`
+
+func TestAPI_CreateAndGet(t *testing.T) {
+	router := newTestRouter()
+
+	w := doRequest(router, http.MethodPost, "/tasks", testTaskBody, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201 but got %d: %s", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") != `"1"` {
+		t.Errorf("unexpected etag %s", w.Header().Get("ETag"))
+	}
+
+	var task Task
+	_ = json.Unmarshal(w.Body.Bytes(), &task)
+
+	w = doRequest(router, http.MethodGet, "/tasks/"+task.ID, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", w.Code)
+	}
+	if w.Header().Get("ETag") != `"1"` {
+		t.Errorf("unexpected etag %s", w.Header().Get("ETag"))
+	}
+
+	w = doRequest(router, http.MethodGet, "/tasks/not-exist", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 but got %d", w.Code)
+	}
+
+	w = doRequest(router, http.MethodPost, "/tasks", `{"title":"test1"}`, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+}
+
+func TestAPI_UpdateIfMatch(t *testing.T) {
+	router := newTestRouter()
+
+	w := doRequest(router, http.MethodPost, "/tasks", testTaskBody, nil)
+	var task Task
+	_ = json.Unmarshal(w.Body.Bytes(), &task)
+	etag := w.Header().Get("ETag")
+
+	// first editor wins
+	w = doRequest(router, http.MethodPut, "/tasks/"+task.ID, testTaskBody, map[string]string{"If-Match": etag})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d: %s", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") != `"2"` {
+		t.Errorf("unexpected etag %s", w.Header().Get("ETag"))
+	}
+
+	// second editor with the stale etag lose
+	w = doRequest(router, http.MethodPut, "/tasks/"+task.ID, testTaskBody, map[string]string{"If-Match": etag})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412 but got %d", w.Code)
+	}
+
+	// weak tags never match with strong comparison
+	w = doRequest(router, http.MethodPut, "/tasks/"+task.ID, testTaskBody, map[string]string{"If-Match": `W/"2"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412 but got %d", w.Code)
+	}
+
+	// any tag of a list can match
+	w = doRequest(router, http.MethodPut, "/tasks/"+task.ID, testTaskBody, map[string]string{"If-Match": `"1", W/"3", "2"`})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d: %s", w.Code, w.Body)
+	}
+
+	w = doRequest(router, http.MethodPut, "/tasks/"+task.ID, testTaskBody, map[string]string{"If-Match": "bad"})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412 but got %d", w.Code)
+	}
+
+	// unconditional update
+	w = doRequest(router, http.MethodPut, "/tasks/"+task.ID, testTaskBody, nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", w.Code)
+	}
+
+	w = doRequest(router, http.MethodPut, "/tasks/not-exist", testTaskBody, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 but got %d", w.Code)
+	}
+}
+
+func TestAPI_DeleteIfMatch(t *testing.T) {
+	router := newTestRouter()
+
+	w := doRequest(router, http.MethodPost, "/tasks", testTaskBody, nil)
+	var task Task
+	_ = json.Unmarshal(w.Body.Bytes(), &task)
+
+	w = doRequest(router, http.MethodDelete, "/tasks/"+task.ID, "", map[string]string{"If-Match": `"5"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412 but got %d", w.Code)
+	}
+
+	w = doRequest(router, http.MethodDelete, "/tasks/"+task.ID, "", map[string]string{"If-Match": `"1"`})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204 but got %d", w.Code)
+	}
+
+	w = doRequest(router, http.MethodDelete, "/tasks/"+task.ID, "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 but got %d", w.Code)
+	}
+}
+
+func TestAPI_ListPages(t *testing.T) {
+	emptyBucket()
+	router := newTestRouter()
+
+	for i := 0; i < 5; i++ {
+		doRequest(router, http.MethodPost, "/tasks", testTaskBody, nil)
+	}
+
+	var all []Task
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		w := doRequest(router, http.MethodGet, "/tasks?limit=2&cursor="+cursor, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200 but got %d: %s", w.Code, w.Body)
+		}
+		var page []Task
+		_ = json.Unmarshal(w.Body.Bytes(), &page)
+		if len(page) > 2 {
+			t.Errorf("page has %d tasks", len(page))
+		}
+		all = append(all, page...)
+
+		cursor = w.Header().Get(nextCursorHeader)
+		if cursor == "" {
+			break
+		}
+	}
+	if len(all) != 5 {
+		t.Errorf("expected 5 tasks in all pages but got %d", len(all))
+	}
+
+	w := doRequest(router, http.MethodGet, "/tasks?limit=-1", "", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+	w = doRequest(router, http.MethodGet, "/tasks?cursor=!!", "", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 but got %d", w.Code)
+	}
+}
+
+func TestAPI_ReadOnly(t *testing.T) {
+	router := newTestRouter()
+	store := GetRepository().DBService
+	if err := store.SetReadOnly(true); err != nil {
+		t.Fatal(err)
+	}
+	defer store.SetReadOnly(false)
+
+	w := doRequest(router, http.MethodPost, "/tasks", testTaskBody, nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 in read-only mode but got %d", w.Code)
+	}
+	w = doRequest(router, http.MethodGet, "/tasks", "", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected reads to work in read-only mode but got %d", w.Code)
+	}
+}
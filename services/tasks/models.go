@@ -8,6 +8,8 @@ type Task struct {
 	Estimate string `json:"estimate"`
 	Status   string `json:"status"`
 	Assignee string `json:"assignee"`
+	// Version is the record version in db, exposed as ETag
+	Version uint64 `json:"-"`
 }
 
 // TaskRequest create or update task request
@@ -18,3 +20,14 @@ type TaskRequest struct {
 	Status   string `form:"status" json:"status" xml:"status" binding:"required"`
 	Assignee string `form:"assignee" json:"assignee" xml:"assignee" binding:"required"`
 }
+
+// Task return a task filled by request fields
+func (r TaskRequest) Task() Task {
+	return Task{
+		Title:    r.Title,
+		Sprint:   r.Sprint,
+		Estimate: r.Estimate,
+		Status:   r.Status,
+		Assignee: r.Assignee,
+	}
+}
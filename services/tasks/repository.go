@@ -1,23 +1,37 @@
 package tasks
 
 import (
+	"errors"
 	"fmt"
-	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
 	"log"
 
 	"github.com/mirzakhany/rest_api_sample/pkg/db"
+	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
 	"github.com/mirzakhany/rest_api_sample/pkg/registry"
-
-	"github.com/google/uuid"
+	"github.com/mirzakhany/rest_api_sample/pkg/web"
 )
 
 // BucketName repository bucket name
 const BucketName = "tasks"
 
+// indexes of tasks bucket
+const (
+	IndexSprint   = "sprint"
+	IndexAssignee = "assignee"
+	IndexStatus   = "status"
+)
+
+// ErrNotFound is returned when task is not exist, it
+// is matched by errors.Is(err, db.ErrNotFound) too
+var ErrNotFound = fmt.Errorf("task %w", db.ErrNotFound)
+
 var repo *Repository
 
+// Repository store tasks in a db collection, task ID is the record key
+// and task Version is the record version
 type Repository struct {
-	DBService *db.Service
+	DBService db.Store
+	Tasks     *db.Collection[Task]
 }
 
 func New(ctx *projectx.Ctx) *Repository {
@@ -27,85 +41,138 @@ func New(ctx *projectx.Ctx) *Repository {
 		log.Panic("could not get database connection pool from context")
 	}
 
-	dbService := i.(*db.Service)
+	dbService := i.(db.Store)
 
-	_, err := dbService.CreateBucket(BucketName)
-	if err != nil {
-		log.Panicf("create bucket %s failed: %s", BucketName, err.Error())
+	// a read-only file can not be changed, the bucket should already exist
+	if !dbService.ReadOnly() {
+		err := dbService.CreateBucket(BucketName)
+		if err != nil {
+			log.Panicf("create bucket %s failed: %s", BucketName, err.Error())
+		}
 	}
-	repo = &Repository{DBService: dbService}
+	repo = &Repository{DBService: dbService, Tasks: newCollection(dbService)}
 	return repo
 }
 
+func newCollection(store db.Store) *db.Collection[Task] {
+	return db.NewCollection(store, BucketName,
+		db.BeforeSave(func(tx *db.Tx, key string, task *Task) error {
+			task.ID = key
+			return nil
+		}),
+		db.AfterLoad(func(tx *db.Tx, key string, task *Task) error {
+			var err error
+			task.Version, err = tx.Version(key, BucketName)
+			return err
+		}),
+	)
+}
+
 func GetRepository() *Repository {
 	return repo
 }
 
-func (r *Repository) Create(task Task) (Task, error) {
-	taskID := uuid.New().String()
-	task.ID = taskID
+// notFound replace db.ErrNotFound with ErrNotFound of the task
+func notFound(err error, taskID string) error {
+	if errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotFound, taskID)
+	}
+	return err
+}
 
-	err := r.DBService.SetJson(taskID, BucketName, task)
+func (r *Repository) Create(task Task) (Task, error) {
+	_, task, err := r.Tasks.Insert(task)
 	return task, err
 }
 
-func (r *Repository) Update(taskID string, task Task) (Task, error) {
+// CreateMany create tasks in chunked transactions, e.g. for bulk imports.
+// tasks of committed chunks are returned with the error if a chunk fails
+func (r *Repository) CreateMany(tasks []Task) ([]Task, error) {
+	return r.Tasks.InsertMany(tasks)
+}
 
-	ok, err := r.DBService.IsExist(taskID, BucketName)
+func (r *Repository) Update(taskID string, task Task) (Task, error) {
+	task, err := r.Tasks.Update(taskID, task)
 	if err != nil {
-		return Task{}, err
-	}
-
-	if !ok {
-		return Task{}, fmt.Errorf("task not found: %s", taskID)
+		return Task{}, notFound(err, taskID)
 	}
+	return task, nil
+}
 
-	task.ID = taskID
-	err = r.DBService.SetJson(taskID, BucketName, task)
+// UpdateIfVersion update the task only if it is not changed since
+// the given version, a *db.ConflictError is returned otherwise
+func (r *Repository) UpdateIfVersion(taskID string, task Task, version uint64) (Task, error) {
+	task, err := r.Tasks.UpdateIfVersion(taskID, task, version)
 	if err != nil {
-		return Task{}, err
+		return Task{}, notFound(err, taskID)
 	}
-
 	return task, nil
 }
 
 func (r *Repository) Delete(taskID string) error {
+	return notFound(r.Tasks.Delete(taskID), taskID)
+}
 
-	ok, err := r.DBService.IsExist(taskID, BucketName)
-	if err != nil {
-		return err
-	}
-
-	if !ok {
-		return fmt.Errorf("task not found: %s", taskID)
-	}
-
-	return r.DBService.Delete(taskID, BucketName)
+// DeleteIfVersion delete the task only if it is not changed since
+// the given version, a *db.ConflictError is returned otherwise
+func (r *Repository) DeleteIfVersion(taskID string, version uint64) error {
+	return notFound(r.Tasks.DeleteIfVersion(taskID, version), taskID)
 }
 
 func (r *Repository) GetOne(taskID string) (Task, error) {
-
-	var task Task
-	err := r.DBService.GetJson(taskID, BucketName, &task)
+	task, err := r.Tasks.Get(taskID)
 	if err != nil {
-		return Task{}, err
+		return Task{}, notFound(err, taskID)
 	}
 	return task, nil
 }
 
 func (r *Repository) GetAll() ([]Task, error) {
-	var tasks []Task
-	err := r.DBService.GetJsonList(BucketName, &tasks)
-	if err != nil {
-		return nil, err
-	}
-	return tasks, nil
+	tasks, _, err := r.Tasks.List("", 0)
+	return tasks, err
+}
+
+// List return at most limit tasks ordered by id starting after cursor,
+// next is the cursor of the following page and is empty on the last page
+func (r *Repository) List(cursor string, limit int) (tasks []Task, next string, err error) {
+	return r.Tasks.List(cursor, limit)
+}
+
+// FindBySprint return tasks of a sprint
+func (r *Repository) FindBySprint(sprint string) ([]Task, error) {
+	return r.Tasks.Find(IndexSprint, sprint)
+}
+
+// FindByAssignee return tasks assigned to assignee
+func (r *Repository) FindByAssignee(assignee string) ([]Task, error) {
+	return r.Tasks.Find(IndexAssignee, assignee)
+}
+
+// FindByStatus return tasks in the given status
+func (r *Repository) FindByStatus(status string) ([]Task, error) {
+	return r.Tasks.Find(IndexStatus, status)
 }
 
 func init() {
+	db.RegisterIndex(BucketName, db.JsonIndex(IndexSprint, Task{}, func(r interface{}) []string {
+		return []string{r.(*Task).Sprint}
+	}))
+	db.RegisterIndex(BucketName, db.JsonIndex(IndexAssignee, Task{}, func(r interface{}) []string {
+		return []string{r.(*Task).Assignee}
+	}))
+	db.RegisterIndex(BucketName, db.JsonIndex(IndexStatus, Task{}, func(r interface{}) []string {
+		return []string{r.(*Task).Status}
+	}))
+	db.RegisterModel(BucketName, Task{})
+	// task changes can be watched, e.g. for live board updates
+	db.RegisterChangeLog(BucketName)
+
 	// make sure that our bucket is exit
 	registry.Register(func(ctx *projectx.Ctx) error {
 		New(ctx)
+		if router, ok := web.Router(ctx); ok {
+			RegisterRoutes(router.Group("/tasks"))
+		}
 		return nil
 	}, 5, true)
 }
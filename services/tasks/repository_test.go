@@ -2,6 +2,7 @@ package tasks
 
 import (
 	"context"
+	"errors"
 	"github.com/mirzakhany/rest_api_sample/pkg/projectx"
 	"os"
 	"testing"
@@ -11,19 +12,13 @@ import (
 
 func TestMain(m *testing.M) {
 
-	dbService, err := db.New("/tmp/test_tasks")
-	if err != nil {
-		panic(err)
-	}
+	dbService := db.NewMemory()
 
 	ctx := projectx.New(context.Background())
 	ctx.Set(db.ContextKey, dbService)
 	New(ctx)
 
-	code := m.Run()
-
-	_ = os.Remove("/tmp/test_tasks")
-	os.Exit(code)
+	os.Exit(m.Run())
 }
 
 func TestGetRepository(t *testing.T) {
@@ -177,6 +172,145 @@ func TestRepository_GetAll(t *testing.T) {
 	}
 }
 
+func TestRepository_CreateMany(t *testing.T) {
+
+	emptyBucket()
+
+	var tasks = []Task{
+		{Title: "test1", Sprint: "bar", Status: "in-progress", Assignee: "foo"},
+		{Title: "test2", Sprint: "bar", Status: "done", Assignee: "foo"},
+	}
+
+	repo := GetRepository()
+
+	created, err := repo.CreateMany(tasks)
+	if err != nil {
+		t.Fatalf("error in create tasks, %s", err)
+	}
+	for _, task := range created {
+		if task.ID == "" || task.Version != 1 {
+			t.Errorf("created task should have id and version, got %+v", task)
+		}
+	}
+	savedTasks, _ := repo.FindBySprint("bar")
+	if len(savedTasks) != len(tasks) {
+		t.Errorf("result count in not same as input %d != %d", len(savedTasks), len(tasks))
+	}
+}
+
+func TestRepository_UpdateIfVersion(t *testing.T) {
+
+	res, err := GetRepository().Create(Task{Title: "test1"})
+	if err != nil {
+		t.Errorf("error in create task, %s", err)
+	}
+	if res.Version != 1 {
+		t.Errorf("new task should have version 1, %d", res.Version)
+	}
+
+	updated, err := GetRepository().UpdateIfVersion(res.ID, Task{Title: "test2"}, res.Version)
+	if err != nil {
+		t.Errorf("error in update task, %s", err)
+	}
+	if updated.Version != 2 {
+		t.Errorf("updated task should have version 2, %d", updated.Version)
+	}
+
+	_, err = GetRepository().UpdateIfVersion(res.ID, Task{Title: "test3"}, res.Version)
+	var conflict *db.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Errorf("expected conflict error but got %v", err)
+	}
+
+	saved, _ := GetRepository().GetOne(res.ID)
+	if saved.Title != "test2" || saved.Version != 2 {
+		t.Errorf("stale update is saved, %v", saved)
+	}
+}
+
+func TestRepository_UpdateNotExist(t *testing.T) {
+
+	_, err := GetRepository().Update("not-exist", Task{Title: "test"})
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("update should fail with ErrNotFound when task not exist, got %v", err)
+	}
+
+	_, err = GetRepository().GetOne("not-exist")
+	if err == nil {
+		t.Error("update should not create the task")
+	}
+}
+
+func TestRepository_DeleteNotExist(t *testing.T) {
+
+	err := GetRepository().Delete("not-exist")
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("delete should fail with ErrNotFound when task not exist, got %v", err)
+	}
+}
+
+func TestRepository_List(t *testing.T) {
+
+	emptyBucket()
+
+	repo := GetRepository()
+	for i := 0; i < 3; i++ {
+		if _, err := repo.Create(Task{Title: "test"}); err != nil {
+			t.Errorf("error in create task, %s", err)
+		}
+	}
+
+	page, next, err := repo.List("", 2)
+	if err != nil {
+		t.Errorf("error in listing tasks, %s", err)
+	}
+	if len(page) != 2 || next != page[1].ID {
+		t.Errorf("unexpected first page %v, next %s", page, next)
+	}
+
+	page, next, err = repo.List(next, 2)
+	if err != nil {
+		t.Errorf("error in listing tasks, %s", err)
+	}
+	if len(page) != 1 || next != "" {
+		t.Errorf("unexpected last page %v, next %s", page, next)
+	}
+}
+
+func TestRepository_FindBy(t *testing.T) {
+
+	emptyBucket()
+
+	repo := GetRepository()
+	var tasks = []Task{
+		{Title: "test1", Sprint: "s1", Status: "done", Assignee: "foo"},
+		{Title: "test2", Sprint: "s1", Status: "in-progress", Assignee: "bar"},
+		{Title: "test3", Sprint: "s2", Status: "done", Assignee: "foo"},
+	}
+	for _, task := range tasks {
+		if _, err := repo.Create(task); err != nil {
+			t.Errorf("error in create task, %s", err)
+		}
+	}
+
+	found, err := repo.FindBySprint("s1")
+	if err != nil || len(found) != 2 {
+		t.Errorf("expected 2 tasks in sprint s1, %v, %v", found, err)
+	}
+	found, err = repo.FindByAssignee("foo")
+	if err != nil || len(found) != 2 {
+		t.Errorf("expected 2 tasks for foo, %v, %v", found, err)
+	}
+	found, err = repo.FindByStatus("in-progress")
+	if err != nil || len(found) != 1 || found[0].Title != "test2" {
+		t.Errorf("expected 1 task in progress, %v, %v", found, err)
+	}
+	found, err = repo.FindByStatus("not-exist")
+	if err != nil || len(found) != 0 {
+		t.Errorf("expected no task, %v, %v", found, err)
+	}
+}
+
 func emptyBucket() {
 	repo := GetRepository()
 	savedTasks, _ := repo.GetAll()